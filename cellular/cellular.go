@@ -0,0 +1,328 @@
+// Package cellular is a small cellular automaton engine. A Grid of cells is
+// stepped forward by a Rule, which sees each cell along with the cells in its
+// Neighbourhood. 1D automata are just grids with a height of one.
+package cellular
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The state of a single cell. State 0 is the background state: anything
+// outside of a grid is taken to be in it.
+type State uint8
+
+// The most states a rule can distinguish between
+const MaxStates = 16
+
+// A Grid is a rectangle of cells. Origin is the coordinate of the top left
+// cell, which is (0, 0) unless the grid has grown.
+type Grid struct {
+	width int
+	height int
+	origin [2]int
+	cells []State
+}
+
+func NewGrid(width, height int) *Grid {
+	return &Grid{width, height, [2]int{0, 0}, make([]State, width*height)}
+}
+
+/** Read a grid from lines of text, with each character's position in the
+ * alphabet giving its state
+ */
+func ParseGrid(lines []string, alphabet string) (*Grid, error) {
+	width := 0
+	for _, line := range lines {
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+	g := NewGrid(width, len(lines))
+	for y, line := range lines {
+		for x, c := range line {
+			s := strings.IndexRune(alphabet, c)
+			if s < 0 {
+				return nil, fmt.Errorf("Unknown cell '%c' at %d, %d", c, x, y)
+			}
+			g.cells[y*width + x] = State(s)
+		}
+	}
+	return g, nil
+}
+
+func (g *Grid) Width() int { return g.width }
+func (g *Grid) Height() int { return g.height }
+func (g *Grid) Origin() [2]int { return g.origin }
+
+func (g *Grid) contains(x, y int) bool {
+	x -= g.origin[0]
+	y -= g.origin[1]
+	return x >= 0 && y >= 0 && x < g.width && y < g.height
+}
+
+/** Get the state of the cell at (x, y) */
+func (g *Grid) Get(x, y int) State {
+	if !g.contains(x, y) {
+		return 0
+	}
+	return g.cells[(y - g.origin[1])*g.width + x - g.origin[0]]
+}
+
+/** Set the state of the cell at (x, y), growing the grid if it lies outside */
+func (g *Grid) Set(x, y int, s State) {
+	if !g.contains(x, y) {
+		min := [2]int{x, y}
+		max := [2]int{x + 1, y + 1}
+		if g.width > 0 && g.height > 0 {
+			min = [2]int{minInt(x, g.origin[0]), minInt(y, g.origin[1])}
+			max = [2]int{maxInt(x + 1, g.origin[0] + g.width), maxInt(y + 1, g.origin[1] + g.height)}
+		}
+		*g = *g.Resized(min, max)
+	}
+	g.cells[(y - g.origin[1])*g.width + x - g.origin[0]] = s
+}
+
+/** A copy of the grid covering min (inclusive) to max (exclusive). Cells
+ * outside of the original grid are background.
+ */
+func (g *Grid) Resized(min, max [2]int) *Grid {
+	r := NewGrid(max[0] - min[0], max[1] - min[1])
+	r.origin = min
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			r.cells[y*r.width + x] = g.Get(x + min[0], y + min[1])
+		}
+	}
+	return r
+}
+
+func (g *Grid) Copy() *Grid {
+	c := *g
+	c.cells = append([]State{}, g.cells...)
+	return &c
+}
+
+/** Bounds of the cells which aren't background, as min (inclusive) and max
+ * (exclusive). ok is false if every cell is background.
+ */
+func (g *Grid) LiveBounds() (min, max [2]int, ok bool) {
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			if g.cells[y*g.width + x] == 0 {
+				continue
+			}
+			wx, wy := x + g.origin[0], y + g.origin[1]
+			if !ok {
+				min, max, ok = [2]int{wx, wy}, [2]int{wx + 1, wy + 1}, true
+				continue
+			}
+			min = [2]int{minInt(min[0], wx), minInt(min[1], wy)}
+			max = [2]int{maxInt(max[0], wx + 1), maxInt(max[1], wy + 1)}
+		}
+	}
+	return min, max, ok
+}
+
+/** Number of cells in state s */
+func (g *Grid) Count(s State) int {
+	n := 0
+	for _, c := range g.cells {
+		if c == s {
+			n++
+		}
+	}
+	return n
+}
+
+/** Call f for each cell which isn't background */
+func (g *Grid) EachLive(f func(x, y int, s State)) {
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			if s := g.cells[y*g.width + x]; s != 0 {
+				f(x + g.origin[0], y + g.origin[1], s)
+			}
+		}
+	}
+}
+
+/** True if both grids have the same size, position and cells */
+func (g *Grid) Equal(o *Grid) bool {
+	if g.width != o.width || g.height != o.height || g.origin != o.origin {
+		return false
+	}
+	for i := range g.cells {
+		if g.cells[i] != o.cells[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/** Render the grid as text, one line per row */
+func (g *Grid) Format(alphabet string) string {
+	var sb strings.Builder
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			sb.WriteByte(alphabet[g.cells[y*g.width + x]])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Neighbourhood is the list of offsets a rule looks at around each cell
+type Neighbourhood [][2]int
+
+/** A 1D window of cells from x-r to x+r, including the cell itself. This is
+ * what PatternRule expects.
+ */
+func Radius1D(r int) Neighbourhood {
+	n := make(Neighbourhood, 0, 2*r+1)
+	for dx := -r; dx <= r; dx++ {
+		n = append(n, [2]int{dx, 0})
+	}
+	return n
+}
+
+/** Every cell within a square of radius r, not including the cell itself */
+func Moore(r int) Neighbourhood {
+	n := make(Neighbourhood, 0)
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx != 0 || dy != 0 {
+				n = append(n, [2]int{dx, dy})
+			}
+		}
+	}
+	return n
+}
+
+/** Every cell within a manhattan distance of r, not including the cell itself */
+func VonNeumann(r int) Neighbourhood {
+	n := make(Neighbourhood, 0)
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if (dx != 0 || dy != 0) && absInt(dx) + absInt(dy) <= r {
+				n = append(n, [2]int{dx, dy})
+			}
+		}
+	}
+	return n
+}
+
+/** How far the neighbourhood reaches along each axis */
+func (n Neighbourhood) Reach() [2]int {
+	var reach [2]int
+	for _, off := range n {
+		reach[0] = maxInt(reach[0], absInt(off[0]))
+		reach[1] = maxInt(reach[1], absInt(off[1]))
+	}
+	return reach
+}
+
+// Boundary says what a rule sees when it looks past the edge of the grid
+type Boundary int
+const (
+	Fixed Boundary = iota // Cells past the edge are background
+	Wrap // The grid wraps around at the edges
+	Growing // The grid grows to fit whatever the live cells turn into
+)
+
+func ParseBoundary(s string) (Boundary, error) {
+	switch s {
+	case "fixed":
+		return Fixed, nil
+	case "wrap":
+		return Wrap, nil
+	case "growing":
+		return Growing, nil
+	}
+	return 0, fmt.Errorf("Unknown boundary %q", s)
+}
+
+type Automaton struct {
+	grid *Grid
+	neighbourhood Neighbourhood
+	rule Rule
+	boundary Boundary
+	generation int
+	window []State
+}
+
+func NewAutomaton(grid *Grid, neighbourhood Neighbourhood, rule Rule, boundary Boundary) *Automaton {
+	if boundary == Growing {
+		// A growing grid only stays finite if empty space stays empty
+		window := make([]State, len(neighbourhood))
+		if rule.Next(0, window) != 0 {
+			panic("Growing boundary needs a rule which keeps background cells with no live neighbours in the background")
+		}
+	}
+	return &Automaton{grid, neighbourhood, rule, boundary, 0, make([]State, len(neighbourhood))}
+}
+
+func (a *Automaton) Grid() *Grid { return a.grid }
+func (a *Automaton) Generation() int { return a.generation }
+
+func (a *Automaton) lookup(g *Grid, x, y int) State {
+	if a.boundary == Wrap {
+		x = g.origin[0] + mod(x - g.origin[0], g.width)
+		y = g.origin[1] + mod(y - g.origin[1], g.height)
+	}
+	return g.Get(x, y)
+}
+
+/** Advance the automaton by one generation */
+func (a *Automaton) Step() {
+	g := a.grid
+	if a.boundary == Growing {
+		// Anything within reach of a live cell might come alive, so make
+		// room for it. This also trims off any dead space.
+		reach := a.neighbourhood.Reach()
+		min, max, ok := g.LiveBounds()
+		if !ok {
+			a.generation++
+			return
+		}
+		g = g.Resized([2]int{min[0] - reach[0], min[1] - reach[1]}, [2]int{max[0] + reach[0], max[1] + reach[1]})
+	}
+
+	next := NewGrid(g.width, g.height)
+	next.origin = g.origin
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			wx, wy := x + g.origin[0], y + g.origin[1]
+			for i, off := range a.neighbourhood {
+				a.window[i] = a.lookup(g, wx + off[0], wy + off[1])
+			}
+			next.cells[y*g.width + x] = a.rule.Next(g.cells[y*g.width + x], a.window)
+		}
+	}
+	a.grid = next
+	a.generation++
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func mod(a, n int) int {
+	return ((a % n) + n) % n
+}
@@ -0,0 +1,132 @@
+package cellular
+
+import (
+	"hash/fnv"
+)
+
+// A Tracker remembers every generation of an automaton it's shown, and spots
+// when a state comes round again. Once it has, the state at any later
+// generation can be worked out without stepping the automaton there.
+//
+// With translate set, states are compared ignoring where they are on the
+// grid. A pattern which repeats in a new position has a steady drift, which
+// carries on by the same shift every period.
+type Tracker struct {
+	translate bool
+	first int // Generation of the first state added
+	states []*Grid // With the origin at (0, 0) when translating
+	offsets [][2]int // Where each state was on the grid
+	byHash map[uint64][]int
+	repeating bool
+	start int
+	period int
+	shift [2]int
+}
+
+func NewTracker(translate bool) *Tracker {
+	return &Tracker{translate: translate, byHash: make(map[uint64][]int)}
+}
+
+func hashGrid(g *Grid, withOrigin bool) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 0, 4*8 + len(g.cells))
+	put := func(v int) {
+		for i := 0; i < 8; i++ {
+			buf = append(buf, byte(v >> uint(8*i)))
+		}
+	}
+	put(g.width)
+	put(g.height)
+	if withOrigin {
+		put(g.origin[0])
+		put(g.origin[1])
+	}
+	for _, c := range g.cells {
+		buf = append(buf, byte(c))
+	}
+	h.Write(buf)
+	return h.Sum64()
+}
+
+/** Record the state at the next generation. The first call sets which
+ * generation that is. Returns true once a repeat has been found.
+ */
+func (t *Tracker) Add(generation int, g *Grid) bool {
+	if t.repeating {
+		return true
+	}
+	if len(t.states) == 0 {
+		t.first = generation
+	}
+	state := g.Copy()
+	offset := g.origin
+	if t.translate {
+		if min, max, ok := g.LiveBounds(); ok {
+			state = g.Resized(min, max)
+			offset = min
+		} else {
+			state = NewGrid(0, 0)
+		}
+		state.origin = [2]int{0, 0}
+	}
+
+	h := hashGrid(state, !t.translate)
+	idx := len(t.states)
+	for _, prev := range t.byHash[h] {
+		if t.states[prev].Equal(state) {
+			t.repeating = true
+			t.start = prev
+			t.period = idx - prev
+			t.shift = [2]int{offset[0] - t.offsets[prev][0], offset[1] - t.offsets[prev][1]}
+			return true
+		}
+	}
+	t.byHash[h] = append(t.byHash[h], idx)
+	t.states = append(t.states, state)
+	t.offsets = append(t.offsets, offset)
+	return false
+}
+
+func (t *Tracker) Repeating() bool {
+	return t.repeating
+}
+
+/** The generation the repeating section starts at, how many generations it
+ * lasts, and how far the pattern moves each time round
+ */
+func (t *Tracker) Cycle() (start, period int, shift [2]int) {
+	return t.first + t.start, t.period, t.shift
+}
+
+/** The state at any generation from the first one added. ok is false if it
+ * hasn't been seen yet and can't be extrapolated.
+ */
+func (t *Tracker) StateAt(generation int) (*Grid, bool) {
+	idx := generation - t.first
+	if idx < 0 {
+		return nil, false
+	}
+	k := 0
+	if idx >= len(t.states) {
+		if !t.repeating {
+			return nil, false
+		}
+		k = (idx - t.start) / t.period
+		idx = t.start + (idx - t.start) % t.period
+	}
+	g := t.states[idx].Copy()
+	g.origin = [2]int{t.offsets[idx][0] + k*t.shift[0], t.offsets[idx][1] + k*t.shift[1]}
+	return g, true
+}
+
+/** Step the automaton, tracking each generation, until a repeat turns up or
+ * maxGenerations have been run. Returns true if a repeat was found.
+ */
+func (t *Tracker) Run(a *Automaton, maxGenerations int) bool {
+	t.Add(a.Generation(), a.Grid())
+	for !t.repeating && a.Generation() < maxGenerations {
+		a.Step()
+		t.Add(a.Generation(), a.Grid())
+	}
+	return t.repeating
+}
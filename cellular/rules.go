@@ -0,0 +1,210 @@
+package cellular
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Rule gives the next state of a cell from its current state and the
+// states of its neighbourhood, in the neighbourhood's order
+type Rule interface {
+	Next(centre State, window []State) State
+}
+
+// PatternRule maps every possible window of cells to a new state, like the
+// "..#.# => #" notes in day 12. Windows with no entry go to background.
+type PatternRule struct {
+	base int
+	size int
+	table []State
+}
+
+func NewPatternRule(numStates, size int) *PatternRule {
+	entries := 1
+	for i := 0; i < size; i++ {
+		entries *= numStates
+	}
+	return &PatternRule{numStates, size, make([]State, entries)}
+}
+
+func (r *PatternRule) index(window []State) int {
+	idx := 0
+	for _, s := range window {
+		idx = idx*r.base + int(s)
+	}
+	return idx
+}
+
+func (r *PatternRule) Set(window []State, result State) {
+	r.table[r.index(window)] = result
+}
+
+func (r *PatternRule) Next(centre State, window []State) State {
+	return r.table[r.index(window)]
+}
+
+// Ops which can be used to compare neighbour counts in a CountRule
+var countOps = map[string]func(a, b int) bool{
+	">=": func(a, b int) bool { return a >= b },
+	"<=": func(a, b int) bool { return a <= b },
+	"==": func(a, b int) bool { return a == b },
+	"!=": func(a, b int) bool { return a != b },
+	">": func(a, b int) bool { return a > b },
+	"<": func(a, b int) bool { return a < b },
+}
+
+type countCondition struct {
+	state State
+	op func(a, b int) bool
+	n int
+}
+
+type countClause struct {
+	anyState bool
+	from State
+	to State
+	conditions []countCondition
+}
+
+// CountRule decides each cell's next state from how many of its neighbours
+// are in each state. Clauses are tried in order and the first one which
+// matches wins; a cell no clause matches stays as it is.
+type CountRule struct {
+	clauses []countClause
+}
+
+func (r *CountRule) Next(centre State, window []State) State {
+	var counts [MaxStates]int
+	for _, s := range window {
+		counts[s]++
+	}
+	for _, c := range r.clauses {
+		if !c.anyState && c.from != centre {
+			continue
+		}
+		match := true
+		for _, cond := range c.conditions {
+			if !cond.op(counts[cond.state], cond.n) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return c.to
+		}
+	}
+	return centre
+}
+
+var patternLineRe = regexp.MustCompile(`^(\S+)\s*=>\s*(\S)$`)
+var countLineRe = regexp.MustCompile(`^(\S)\s*->\s*(\S)(?:\s+when\s+(.+))?$`)
+var conditionRe = regexp.MustCompile(`^(\S)\s*(>=|<=|==|!=|>|<)\s*([0-9]+)$`)
+
+func symbolState(alphabet string, sym string) (State, error) {
+	s := strings.Index(alphabet, sym)
+	if len(sym) != 1 || s < 0 {
+		return 0, fmt.Errorf("Unknown state %q", sym)
+	}
+	return State(s), nil
+}
+
+/** Parse a rule table. The states are named by the characters of alphabet,
+ * with the first being the background state. Two kinds of table are
+ * understood; every line has to be the same kind.
+ *
+ * Pattern tables give the next state for a window of cells:
+ *
+ *     ..#.# => #
+ *
+ * Count tables give transitions based on neighbour counts, and * matches
+ * any state:
+ *
+ *     . -> | when | >= 3
+ *     # -> # when # >= 1, | >= 1
+ *     # -> .
+ *
+ * Blank lines and lines starting with // are ignored.
+ */
+func ParseRules(lines []string, alphabet string) (Rule, error) {
+	if len(alphabet) > MaxStates {
+		return nil, fmt.Errorf("Alphabet %q has more than %d states", alphabet, MaxStates)
+	}
+	var pattern *PatternRule
+	var count *CountRule
+	for lineNum, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		fail := func(err error) (Rule, error) {
+			return nil, fmt.Errorf("line %d: %v", lineNum+1, err)
+		}
+
+		if match := patternLineRe.FindStringSubmatch(line); match != nil && count == nil {
+			if pattern == nil {
+				pattern = NewPatternRule(len(alphabet), len(match[1]))
+			}
+			if len(match[1]) != pattern.size {
+				return fail(fmt.Errorf("pattern %q should be %d cells long", match[1], pattern.size))
+			}
+			window := make([]State, len(match[1]))
+			for i := range match[1] {
+				s, err := symbolState(alphabet, match[1][i:i+1])
+				if err != nil {
+					return fail(err)
+				}
+				window[i] = s
+			}
+			result, err := symbolState(alphabet, match[2])
+			if err != nil {
+				return fail(err)
+			}
+			pattern.Set(window, result)
+			continue
+		}
+
+		if match := countLineRe.FindStringSubmatch(line); match != nil && pattern == nil {
+			if count == nil {
+				count = &CountRule{}
+			}
+			var clause countClause
+			var err error
+			if match[1] == "*" {
+				clause.anyState = true
+			} else if clause.from, err = symbolState(alphabet, match[1]); err != nil {
+				return fail(err)
+			}
+			if clause.to, err = symbolState(alphabet, match[2]); err != nil {
+				return fail(err)
+			}
+			if match[3] != "" {
+				for _, condStr := range strings.Split(match[3], ",") {
+					condMatch := conditionRe.FindStringSubmatch(strings.TrimSpace(condStr))
+					if condMatch == nil {
+						return fail(fmt.Errorf("can't parse condition %q", condStr))
+					}
+					var cond countCondition
+					if cond.state, err = symbolState(alphabet, condMatch[1]); err != nil {
+						return fail(err)
+					}
+					cond.op = countOps[condMatch[2]]
+					cond.n, _ = strconv.Atoi(condMatch[3])
+					clause.conditions = append(clause.conditions, cond)
+				}
+			}
+			count.clauses = append(count.clauses, clause)
+			continue
+		}
+
+		return fail(fmt.Errorf("can't parse rule %q", line))
+	}
+
+	if pattern != nil {
+		return pattern, nil
+	} else if count != nil {
+		return count, nil
+	}
+	return nil, fmt.Errorf("No rules found")
+}
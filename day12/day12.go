@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"os"
 	"regexp"
-	"strings"
+	"github.com/mcbridejc/adventofcode2018/cellular"
 )
 
-func ReadInput(filepath string) (string, map[string]string) {
+// Pots without a plant are the background state
+const alphabet = ".#"
+
+func ReadInput(filepath string) (*cellular.Grid, cellular.Rule) {
 	f, err := os.Open(filepath)
 	if err != nil {
 		panic(err)
@@ -24,109 +27,86 @@ func ReadInput(filepath string) (string, map[string]string) {
 	if match == nil {
 		panic(fmt.Sprintf("No initial state found in %s", scanner.Text()))
 	}
-	initState := match[1]
-
-	stateTable := make(map[string]string)
-
-	re = regexp.MustCompile("([#.]{5}) => ([#.])")
-	for scanner.Scan() {
-		match := re.FindStringSubmatch(scanner.Text())
-		if match == nil {
-			continue
-		}
-		stateTable[match[1]] = match[2]
+	initState, err := cellular.ParseGrid([]string{match[1]}, alphabet)
+	if err != nil {
+		panic(err)
 	}
 
-	return initState, stateTable
-}
-
-func Evolve(state string, stateTable map[string]string) (string, int) {
-	firstIndex := strings.Index(state, "#")
-	lastIndex := strings.LastIndex(state, "#")
-	prependCount := 0
-	appendCount := 0
-
-	if firstIndex < 4 {
-		prependCount = 4 - firstIndex;
-	}
-	if lastIndex >= len(state) - 4 {
-		appendCount = len(state) + 4 - lastIndex
+	// Missing entries result in a '.' so that we can use input that only
+	// defines the states that result in '#' (i.e. the example)
+	lines := make([]string, 0)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
 	}
-	firstIndex += prependCount
-	lastIndex += prependCount
-
-	state = strings.Repeat(".", prependCount) + state + strings.Repeat(".", appendCount)
-
-	newState := []byte(strings.Repeat(".", len(state) + prependCount + appendCount))
-	for i := firstIndex - 2; i<=lastIndex + 2; i += 1 {
-		subStr := state[i-2:i+3]
-		
-		// Assume missing entries result in a '.' so that we can use input
-		// that only defines the states that result in '#' (i.e. the example)
-		tableVal, ok := stateTable[subStr]
-		if !ok {
-			tableVal = "."
-		}
-		
-		newState[i] = tableVal[0]
+	rule, err := cellular.ParseRules(lines, alphabet)
+	if err != nil {
+		panic(err)
 	}
 
-	newStateStr := string(newState)
-	newFirstIndex := strings.Index(newStateStr, "#")
-	newLastIndex := strings.LastIndex(newStateStr, "#")
-	shift := prependCount - newFirstIndex
-	return newStateStr[newFirstIndex:newLastIndex+1], shift
+	return initState, rule
 }
 
-func score(zeroIndex int64, state string) int64 {
+/** Sum of the numbers of all the pots with plants in them */
+func score(state *cellular.Grid) int64 {
 	plantChecksum := int64(0)
-	for i := 0; i < len(state); i += 1 { 
-		if state[i] == '#' {
-			plantChecksum += int64(i) - int64(zeroIndex)
-		}
-	}
+	state.EachLive(func(x, y int, s cellular.State) {
+		plantChecksum += int64(x)
+	})
 	return plantChecksum
 }
 
+/** The state as a string of pots, trimmed to the ones with plants in */
+func formatState(state *cellular.Grid) string {
+	min, max, ok := state.LiveBounds()
+	if !ok {
+		return ""
+	}
+	s := state.Resized(min, max).Format(alphabet)
+	return s[:len(s)-1]
+}
+
 func main() {
+	generations := flag.Int64("generations", 50*1000*1000*1000, "Number of generations for the hashlife run")
 	flag.Parse()
 	inputFile := flag.Args()[0]
 
 	fmt.Println("Reading from ", inputFile)
-	initState, stateTable := ReadInput(inputFile)
+	initState, rule := ReadInput(inputFile)
 
 	// Print the input, just to see that it was read appropriately
-	fmt.Printf("Initial state: '%s'\n", initState)
-	for k, v := range stateTable {
-		fmt.Printf("%s -> %s\n", k, v)
+	fmt.Printf("Initial state: '%s'\n", formatState(initState))
+
+	pots := cellular.NewAutomaton(initState, cellular.Radius1D(2), rule, cellular.Growing)
+
+	// We can't step through 50 billion generations, but the pattern settles
+	// down into something which repeats, usually shifting along a bit each
+	// time. Once we've seen that happen we can skip straight to the end.
+	history := cellular.NewTracker(true)
+	if !history.Run(pots, 1000) {
+		fmt.Println("Pattern didn't repeat in 1000 generations")
+	} else {
+		start, period, shift := history.Cycle()
+		fmt.Printf("Pattern repeats every %d generations from generation %d, moving %d pots\n", period, start, shift[0])
 	}
 
-	// We would do so many generations, but its not computationally feasible
-	// So we'll just do enough for it to stabililze
-	//totalGenerations := int64(50*1000*1000*1000)
-	totalGenerations := int64(200)
-	
-	state := initState
-	var zeroIndex int64
-	for i := int64(0); i < totalGenerations; i += 1 {
-		if i%1000000 == 0 {
-			fmt.Println(i/1000000)
-		}
-		shift := 0
-		state, shift = Evolve(state, stateTable)
-		zeroIndex += int64(shift)
-		fmt.Println("% 4d: ", i+1, (score(zeroIndex, state)))	
+	for gen := 1; gen <= pots.Generation(); gen++ {
+		state, _ := history.StateAt(gen)
+		fmt.Printf("%4d: %d\n", gen, score(state))
 	}
 
-	fmt.Printf("Final state:\n     %s\n", state)
-	plantChecksum := score(zeroIndex, state)
-
+	state, _ := history.StateAt(20)
+	fmt.Printf("State after 20 generations:\n     %s\n", formatState(state))
 	fmt.Println("Part 1\n------")
-	fmt.Printf("Plant count: %d\n", plantChecksum)
+	fmt.Printf("Plant count: %d\n", score(state))
 	fmt.Println("Part 2\n------")
-	// The solution here is incredibly unsatisfying and not general for any input. But it appears
-	// that all the puzzle seeds result in a simple geometric repeating pattern you can detect and
-	// project forward to 50B. So look at the output and sort it. In my case, the answer is 
-	// score(200) + (score(200) - score(199)) * (50e9-200) == 38223 + 186*(50e9-200)
-	fmt.Printf("You do the math.")
+	totalGenerations := 50*1000*1000*1000
+	if state, ok := history.StateAt(totalGenerations); ok {
+		fmt.Printf("Plant count after %d generations: %d\n", totalGenerations, score(state))
+	}
+
+	// Hashlife doesn't need the pattern to repeat exactly, so it works for
+	// any number of generations
+	hl := NewHashLife(initState, rule)
+	hl.Advance(*generations)
+	fmt.Printf("Hashlife plant count after %d generations: %d (%d nodes)\n", *generations, hl.Score(), len(hl.nodes))
 }
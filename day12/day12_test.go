@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"github.com/mcbridejc/adventofcode2018/cellular"
+)
+
+func TestHashLifeMatchesEngine(t *testing.T) {
+	for _, file := range []string{"day12_example.txt", "day12_input.txt"} {
+		initState, rule := ReadInput(file)
+		pots := cellular.NewAutomaton(initState, cellular.Radius1D(2), rule, cellular.Growing)
+		for _, gens := range []int{0, 1, 2, 3, 7, 20, 64, 100, 333, 1000} {
+			for pots.Generation() < gens {
+				pots.Step()
+			}
+			hl := NewHashLife(initState, rule)
+			hl.Advance(int64(gens))
+			if want, got := score(pots.Grid()), hl.Score(); !got.IsInt64() || got.Int64() != want {
+				t.Errorf("%s after %d generations: hashlife %s, engine %d", file, gens, got, want)
+			}
+		}
+	}
+}
+
+func TestHashLifeFiftyBillion(t *testing.T) {
+	initState, rule := ReadInput("day12_input.txt")
+	hl := NewHashLife(initState, rule)
+	hl.Advance(50*1000*1000*1000)
+	if got := hl.Score().String(); got != "9300000001023" {
+		t.Errorf("Hashlife after 50e9 generations: %s, want 9300000001023", got)
+	}
+
+	// The engine can only get there by spotting the pattern repeating
+	pots := cellular.NewAutomaton(initState, cellular.Radius1D(2), rule, cellular.Growing)
+	history := cellular.NewTracker(true)
+	if !history.Run(pots, 1000) {
+		t.Fatal("Pattern didn't repeat in 1000 generations")
+	}
+	if state, _ := history.StateAt(50*1000*1000*1000); score(state) != 9300000001023 {
+		t.Errorf("Engine after 50e9 generations: %d, want 9300000001023", score(state))
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"github.com/mcbridejc/adventofcode2018/cellular"
+)
+
+/* Hashlife, adapted to a row of pots
+ *
+ * The row is stored as a binary tree: a node at level k covers 2^k pots, and
+ * is made of two level k-1 nodes. Identical nodes are only ever built once,
+ * so each can remember what it turns into. Plants spread at most two pots
+ * per generation, so after 2^(k-3) generations the middle half of a level k
+ * node depends only on what's inside it. That gets worked out recursively
+ * from the node's children and memoised, which means a row that keeps
+ * repeating bits of itself can be advanced through billions of generations
+ * without ever being stepped one at a time.
+ */
+
+type hlNode struct {
+	level int
+	left *hlNode
+	right *hlNode
+	alive bool // Only for level 0 nodes
+	pop int64 // Number of plants
+	results map[int]*hlNode // Middle half after 2^j generations, by j
+}
+
+type HashLife struct {
+	table [32]bool
+	nodes map[[2]*hlNode]*hlNode
+	leaves [2]*hlNode
+	empty []*hlNode // Empty node at each level
+	root *hlNode
+	origin int64 // Pot number of the root's first pot
+	generation int64
+}
+
+func NewHashLife(state *cellular.Grid, rule cellular.Rule) *HashLife {
+	h := &HashLife{nodes: make(map[[2]*hlNode]*hlNode)}
+	window := make([]cellular.State, 5)
+	for pattern := 0; pattern < 32; pattern++ {
+		for i := 0; i < 5; i++ {
+			window[i] = cellular.State((pattern >> uint(4-i)) & 1)
+		}
+		h.table[pattern] = rule.Next(window[2], window) != 0
+	}
+	if h.table[0] {
+		panic("Hashlife needs empty pots to stay empty")
+	}
+
+	h.leaves[0] = &hlNode{level: 0}
+	h.leaves[1] = &hlNode{level: 0, alive: true, pop: 1}
+	h.empty = []*hlNode{h.leaves[0]}
+
+	// Build the smallest tree that fits the initial state
+	min, max, ok := state.LiveBounds()
+	if !ok {
+		min, max = [2]int{0, 0}, [2]int{1, 1}
+	}
+	level := 3
+	for 1 << uint(level) < max[0] - min[0] {
+		level++
+	}
+	h.origin = int64(min[0])
+	h.root = h.build(level, func(i int) bool { return state.Get(min[0] + i, 0) != 0 })
+	return h
+}
+
+/** Build a node at the given level, with pot i alive if alive(i) */
+func (h *HashLife) build(level int, alive func(i int) bool) *hlNode {
+	var rec func(level, start int) *hlNode
+	rec = func(level, start int) *hlNode {
+		if level == 0 {
+			if alive(start) {
+				return h.leaves[1]
+			}
+			return h.leaves[0]
+		}
+		half := 1 << uint(level - 1)
+		return h.join(rec(level - 1, start), rec(level - 1, start + half))
+	}
+	return rec(level, 0)
+}
+
+/** The canonical node made of left and right */
+func (h *HashLife) join(left, right *hlNode) *hlNode {
+	key := [2]*hlNode{left, right}
+	if n, ok := h.nodes[key]; ok {
+		return n
+	}
+	n := &hlNode{
+		level: left.level + 1,
+		left: left,
+		right: right,
+		pop: left.pop + right.pop,
+	}
+	h.nodes[key] = n
+	return n
+}
+
+func (h *HashLife) emptyNode(level int) *hlNode {
+	for len(h.empty) <= level {
+		e := h.empty[len(h.empty)-1]
+		h.empty = append(h.empty, h.join(e, e))
+	}
+	return h.empty[level]
+}
+
+/** The pots of a level 3 node, as a bitmask with pot 0 in the top bit */
+func leafBits(n *hlNode) int {
+	bits := 0
+	var rec func(n *hlNode)
+	rec = func(n *hlNode) {
+		if n.level == 0 {
+			bits <<= 1
+			if n.alive {
+				bits |= 1
+			}
+			return
+		}
+		rec(n.left)
+		rec(n.right)
+	}
+	rec(n)
+	return bits
+}
+
+/** The middle half of n after 2^j generations. n must be at least level j+3. */
+func (h *HashLife) advance(n *hlNode, j int) *hlNode {
+	if n.pop == 0 {
+		return h.emptyNode(n.level - 1)
+	}
+	if r, ok := n.results[j]; ok {
+		return r
+	}
+
+	var result *hlNode
+	if n.level == 3 {
+		// A single generation of the middle four pots, straight from the rule
+		bits := leafBits(n)
+		next := make([]bool, 4)
+		for i := 0; i < 4; i++ {
+			next[i] = h.table[(bits >> uint(3 - i)) & 31]
+		}
+		result = h.build(2, func(i int) bool { return next[i] })
+	} else {
+		// Three overlapping children, each advanced to give the middle
+		// three quarters of n partway through
+		mid := h.join(n.left.right, n.right.left)
+		partStep := j
+		if j == n.level - 3 {
+			partStep = j - 1
+		}
+		l := h.advance(n.left, partStep)
+		m := h.advance(mid, partStep)
+		r := h.advance(n.right, partStep)
+		a := h.join(l, m)
+		b := h.join(m, r)
+		if j == n.level - 3 {
+			// Advance the rest of the way while taking the middle half
+			result = h.join(h.advance(a, partStep), h.advance(b, partStep))
+		} else {
+			result = h.join(h.centre(a), h.centre(b))
+		}
+	}
+
+	if n.results == nil {
+		n.results = make(map[int]*hlNode)
+	}
+	n.results[j] = result
+	return result
+}
+
+/** The middle half of n, as it is now */
+func (h *HashLife) centre(n *hlNode) *hlNode {
+	return h.join(n.left.right, n.right.left)
+}
+
+/** Pad the root with empty space either side, keeping it centred */
+func (h *HashLife) expand() {
+	e := h.emptyNode(h.root.level - 1)
+	h.origin -= int64(1) << uint(h.root.level - 1)
+	h.root = h.join(h.join(e, h.root.left), h.join(h.root.right, e))
+}
+
+/** Advance by 2^j generations */
+func (h *HashLife) step(j int) {
+	// Plants can spread 2^(j+1) pots either way, and only the middle half of
+	// the root comes back, so make sure it's all well inside that
+	for h.root.level < j + 3 || h.root.left.left.pop > 0 || h.root.right.right.pop > 0 {
+		h.expand()
+	}
+	h.expand()
+	h.origin += int64(1) << uint(h.root.level - 2)
+	h.root = h.advance(h.root, j)
+	h.generation += int64(1) << uint(j)
+}
+
+/** Advance by any number of generations, a power of two at a time */
+func (h *HashLife) Advance(generations int64) {
+	for j := 62; j >= 0; j-- {
+		if generations & (int64(1) << uint(j)) != 0 {
+			h.step(j)
+		}
+	}
+}
+
+/** Sum of the numbers of all the pots with plants in them. This can get far
+ * too big for an int64 when the pattern keeps growing.
+ */
+func (h *HashLife) Score() *big.Int {
+	// Sum of plant positions from the start of each node
+	sums := make(map[*hlNode]*big.Int)
+	var sum func(n *hlNode) *big.Int
+	sum = func(n *hlNode) *big.Int {
+		if n.pop == 0 || n.level == 0 {
+			return big.NewInt(0)
+		}
+		if s, ok := sums[n]; ok {
+			return s
+		}
+		s := new(big.Int).Lsh(big.NewInt(n.right.pop), uint(n.level - 1))
+		s.Add(s, sum(n.left))
+		s.Add(s, sum(n.right))
+		sums[n] = s
+		return s
+	}
+	total := new(big.Int).Mul(big.NewInt(h.origin), big.NewInt(h.root.pop))
+	return total.Add(total, sum(h.root))
+}
+
+/** The pots as a string, trimmed to the ones with plants in */
+func (h *HashLife) String() string {
+	// Only look inside nodes with plants in, so we never walk the empty
+	// space around the row
+	plants := make([]int64, 0, h.root.pop)
+	var rec func(n *hlNode, start int64)
+	rec = func(n *hlNode, start int64) {
+		if n.pop == 0 {
+			return
+		}
+		if n.level == 0 {
+			plants = append(plants, start)
+			return
+		}
+		rec(n.left, start)
+		rec(n.right, start + int64(1) << uint(n.level - 1))
+	}
+	rec(h.root, 0)
+	if len(plants) == 0 {
+		return ""
+	}
+	row := []byte(strings.Repeat(".", int(plants[len(plants)-1] - plants[0] + 1)))
+	for _, p := range plants {
+		row[p - plants[0]] = '#'
+	}
+	return string(row)
+}
@@ -30,18 +30,65 @@ const (
 	Left CartDir = 3
 )
 
+func (d CartDir) String() string {
+	return [...]string{"up", "right", "down", "left"}[d]
+}
+
+// Per direction x/y step, indexed by CartDir
+var dirStep = [4][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}
+
+// New direction after a curve, indexed by the direction the cart arrived in
+var curveTable = map[Track][4]CartDir{
+	RightCurve: {Right, Up, Left, Down},
+	LeftCurve: {Left, Down, Right, Up},
+}
+
+// Which directions a cart may travel in on each kind of track
+var trackAccepts = map[Track][4]bool{
+	Horizontal: {false, true, false, true},
+	Vertical: {true, false, true, false},
+	RightCurve: {true, true, true, true},
+	LeftCurve: {true, true, true, true},
+	Intersection: {true, true, true, true},
+}
+
+func (t Track) String() string {
+	return [...]string{"blank", "horizontal", "vertical", "right curve", "left curve", "intersection"}[t]
+}
+
+func Reverse(d CartDir) CartDir {
+	return (d + 2) % 4
+}
+
+func ApplyTurn(d CartDir, t Turn) CartDir {
+	return (d + CartDir(t) + 3) % 4
+}
+
 type Cart struct {
+	id int
 	x int
 	y int
 	dir CartDir
-	turnCount int
+	arrived CartDir // Heading the cart had at its previous location...
+	arrivedBounced bool // ...and whether it was leaving there on a bounce
+	bounced bool // Leave in dir on the next move without consulting the track
+	turn Turn // The last turn taken at an intersection...
+	turned bool // ...and whether it was taken on the last move
+	replay bool // Take turn again at the next intersection, rather than asking the policy
 	scheduledForRemoval bool
+	stopped bool
+	policy TurnPolicy
+}
+
+func NewCart(id, x, y int, dir CartDir) *Cart {
+	return &Cart{id: id, x: x, y: y, dir: dir, policy: NewCyclicPolicy()}
 }
 
 type Map struct {
 	locs [][]Track
 	width int
 	height int
+	unknown [][2]int // Locations of characters ReadInput didn't recognise
 }
 
 func NewMap() (*Map) {
@@ -93,21 +140,6 @@ func (list CartList) Less(i, j int) bool {
 func (a CartList) Swap(i, j int) { 
 	a.carts[i], a.carts[j] = a.carts[j], a.carts[i] 
 }
-func (a *CartList) Remove(c *Cart) {
-	index := -1
-	for i, check := range a.carts {
-		if c == check {
-			index = i
-			break
-		}
-	}
-	if index > -1 {
-		a.carts = append(a.carts[:index], a.carts[index+1:]...)
-		// a.carts[index:] = a.carts[index+1:]  // shift left
-		// a.carts = a.carts[:len(a.carts)-1] // truncate last element
-	}
-}
-
 func ReadInput(filepath string) (*Map, CartList) {
 	f, err := os.Open(filepath)
 	if err != nil {
@@ -121,16 +153,16 @@ func ReadInput(filepath string) (*Map, CartList) {
 	for scanner.Scan() {
 		for i, s := range scanner.Text() {
 			if s == '>' {
-				carts = append(carts, &Cart{i, line, Right, 0, false})
+				carts = append(carts, NewCart(len(carts), i, line, Right))
 				s = '-'
 			} else if s == '<' {
-				carts = append(carts, &Cart{i, line, Left, 0, false})
+				carts = append(carts, NewCart(len(carts), i, line, Left))
 				s = '-'
 			} else if s == '^' {
-				carts = append(carts, &Cart{i, line, Up, 0, false})
+				carts = append(carts, NewCart(len(carts), i, line, Up))
 				s = '|'
 			} else if s == 'v' {
-				carts = append(carts, &Cart{i, line, Down, 0, false})
+				carts = append(carts, NewCart(len(carts), i, line, Down))
 				s = '|'
 			}
 
@@ -145,6 +177,8 @@ func ReadInput(filepath string) (*Map, CartList) {
 				trackType = LeftCurve
 			} else if s == '+' {
 				trackType = Intersection
+			} else if s != ' ' {
+				m.unknown = append(m.unknown, [2]int{i, line})
 			}
 			m.Set(i, line, trackType)
 		}
@@ -153,136 +187,182 @@ func ReadInput(filepath string) (*Map, CartList) {
 	return m, CartList{carts}
 }
 
-func MoveCart(tracks *Map, c *Cart) {
+/** Work out which way a cart leaves its current location, given the way it
+ * arrived. Asks the cart's policy for a turn at intersections.
+ */
+func ExitDir(tracks *Map, c *Cart) CartDir {
+	if c.bounced {
+		c.bounced = false
+		return c.dir
+	}
 	t := tracks.Get(c.x, c.y)
-	switch c.dir {
-	case Up:
-		if t == Horizontal {
-			panic(fmt.Sprintf("Kart going up on horizontal track @ (%d, %d)", c.x, c.y))
-		} else if t == Vertical {
-			c.y -= 1
-		} else if t == RightCurve {
-			c.dir = Right
-			c.x += 1
-		} else if t == LeftCurve {
-			c.dir = Left
-			c.x -= 1
-		} else if t == Intersection {
-			switch c.turnCount % 3 {
-			case 0: // Turn left
-				c.dir = Left
-				c.x -= 1
-			case 1: // Go straigt
-				c.y -= 1
-			case 2: // Turn Right
-				c.dir = Right
-				c.x += 1
-			}
-			c.turnCount += 1
-		}
-	case Right:
-		if t == Horizontal {
-			c.x += 1
-		} else if t == Vertical {
-			panic(fmt.Sprintf("Kart going right on vertical track @ (%d, %d)", c.x, c.y))
-		} else if t == RightCurve {
-			c.dir = Up
-			c.y -= 1
-		} else if t == LeftCurve {
-			c.dir = Down
-			c.y += 1
-		} else if t == Intersection {
-			switch c.turnCount % 3 {
-			case 0: // Turn left
-				c.dir = Up
-				c.y -= 1
-			case 1: // Go straigt
-				c.x += 1
-			case 2: // Turn Right
-				c.dir = Down
-				c.y += 1
-			}
-			c.turnCount += 1
+	if !trackAccepts[t][c.dir] {
+		panic(fmt.Sprintf("Kart going %s on %s track @ (%d, %d)", c.dir, t, c.x, c.y))
+	}
+	switch t {
+	case RightCurve, LeftCurve:
+		return curveTable[t][c.dir]
+	case Intersection:
+		if c.replay {
+			c.replay = false
+		} else {
+			c.turn = c.policy.NextTurn()
 		}
-	case Down:
-		if t == Horizontal {
-			panic(fmt.Sprintf("Kart going down on horizontal track @ (%d, %d)", c.x, c.y))
-		} else if t == Vertical {
-			c.y += 1
-		} else if t == RightCurve {
-			c.dir = Left
-			c.x -= 1
-		} else if t == LeftCurve {
-			c.dir = Right
-			c.x += 1
-		} else if t == Intersection {
-			switch c.turnCount % 3 {
-			case 0: // Turn left
-				c.dir = Right
-				c.x += 1
-			case 1: // Go straigt
-				c.y += 1
-			case 2: // Turn Right
-				c.dir = Left
-				c.x -= 1
-			}
-			c.turnCount += 1
+		c.turned = true
+		return ApplyTurn(c.dir, c.turn)
+	}
+	return c.dir
+}
+
+/** Take back the turn made on the cart's last move, if it made one, so the
+ * next intersection it reaches gets the same turn rather than the policy's
+ * next one
+ */
+func (c *Cart) undoTurn() {
+	if c.turned {
+		c.turned = false
+		c.replay = true
+	}
+}
+
+func MoveCart(tracks *Map, c *Cart) {
+	c.arrived, c.arrivedBounced = c.dir, c.bounced
+	c.turned = false
+	c.dir = ExitDir(tracks, c)
+	c.x += dirStep[c.dir][0]
+	c.y += dirStep[c.dir][1]
+}
+
+type CollisionMode int
+const (
+	RemoveOnCollision CollisionMode = iota // Both carts are taken off the track
+	StopOnCollision // Both carts stay where they are, blocking the track
+	BounceOnCollision // Both carts reverse back the way they came
+)
+
+func ParseCollisionMode(s string) (CollisionMode, error) {
+	switch s {
+	case "remove":
+		return RemoveOnCollision, nil
+	case "stop":
+		return StopOnCollision, nil
+	case "bounce":
+		return BounceOnCollision, nil
+	}
+	return 0, fmt.Errorf("Unknown collision mode %q", s)
+}
+
+type Crash struct {
+	tick int
+	x int
+	y int
+	carts [2]int // ids of the carts involved
+}
+
+type Sim struct {
+	tracks *Map
+	carts CartList
+	mode CollisionMode
+	tick int
+	crashes []Crash
+}
+
+func NewSim(tracks *Map, carts CartList, mode CollisionMode) *Sim {
+	return &Sim{tracks: tracks, carts: carts, mode: mode}
+}
+
+/** Return the number of carts which are still moving */
+func (s *Sim) Moving() int {
+	n := 0
+	for _, c := range s.carts.carts {
+		if !c.stopped && !c.scheduledForRemoval {
+			n += 1
 		}
-	case Left:
-		if t == Horizontal {
-			c.x -= 1
-		} else if t == Vertical {
-			panic(fmt.Sprintf("Kart going left on vertical track @ (%d, %d)", c.x, c.y))
-		} else if t == RightCurve {
-			c.dir = Down
-			c.y += 1
-		} else if t == LeftCurve {
-			c.dir = Up
-			c.y -= 1
-		} else if t == Intersection {
-			switch c.turnCount % 3 {
-			case 0: // Turn left
-				c.dir = Down
-				c.y += 1
-			case 1: // Go straigt
-				c.x -= 1
-			case 2: // Turn Right
-				c.dir = Up
-				c.y -= 1
-			}
-			c.turnCount += 1
+	}
+	return n
+}
+
+/** Turn a cart around so that its next move takes it back the way it came
+ *
+ * dir is the direction the cart arrived at its location in. The next move
+ * skips the track lookup, so a cart bounced on an intersection doesn't ask
+ * its policy for a fresh turn. If the cart was already due to leave on a
+ * bounce (dir is that direction and bounced is true), turning it around again
+ * puts it back on its original heading and lets the track steer it.
+ */
+func bounce(c *Cart, dir CartDir, bounced bool) {
+	c.dir = Reverse(dir)
+	c.bounced = !bounced
+}
+
+func (s *Sim) collide(c, other *Cart, prevX, prevY int) {
+	s.crashes = append(s.crashes, Crash{s.tick, c.x, c.y, [2]int{c.id, other.id}})
+	switch s.mode {
+	case RemoveOnCollision:
+		c.scheduledForRemoval = true
+		other.scheduledForRemoval = true
+	case StopOnCollision:
+		c.stopped = true
+		other.stopped = true
+	case BounceOnCollision:
+		// The moving cart never gets onto the other one's cell. It's put
+		// back where it was, as if it hadn't moved or turned, and leaves
+		// back the way it came. Nothing else has moved since it set off,
+		// so that cell is still its own, and no two carts ever share one.
+		c.x, c.y = prevX, prevY
+		c.undoTurn()
+		bounce(c, c.arrived, c.arrivedBounced)
+		bounce(other, other.dir, other.bounced)
+	}
+}
+
+/** The cart at (x, y) other than except, if there is one */
+func (s *Sim) cartAt(x, y int, except *Cart) *Cart {
+	for _, other := range s.carts.carts {
+		if other != except && !other.scheduledForRemoval && other.x == x && other.y == y {
+			return other
 		}
 	}
+	return nil
 }
 
-func RunTick(tracks *Map, carts *CartList) (collision bool) {
+/** Advance every cart by one step. Returns true if any carts crashed. */
+func (s *Sim) RunTick() (collision bool) {
 	collision = false
+	s.tick += 1
 
-	sort.Sort(carts)
+	sort.Sort(s.carts)
 
-	collidedCarts := make([]*Cart, 0)
-	for i, c := range carts.carts {
-		MoveCart(tracks, c)
-		for j, other := range carts.carts {
-			if i == j {
-				continue
-			}
-			if c.x == other.x && c.y == other.y && !other.scheduledForRemoval {
-				fmt.Printf("Collision @ %d,%d\n", c.x, c.y)
-				c.scheduledForRemoval = true
-				other.scheduledForRemoval = true
-				collidedCarts = append(collidedCarts, c)
-				collidedCarts = append(collidedCarts, other)
-				collision = true
-			}
+	for _, c := range s.carts.carts {
+		if c.scheduledForRemoval || c.stopped {
+			continue
+		}
+		prevX, prevY := c.x, c.y
+		MoveCart(s.tracks, c)
+		if other := s.cartAt(c.x, c.y, c); other != nil {
+			s.collide(c, other, prevX, prevY)
+			collision = true
 		}
 	}
-	for _, c := range collidedCarts {
-		carts.Remove(c)
+	if s.mode == RemoveOnCollision {
+		remaining := make([]*Cart, 0, len(s.carts.carts))
+		for _, c := range s.carts.carts {
+			if !c.scheduledForRemoval {
+				remaining = append(remaining, c)
+			}
+		}
+		s.carts.carts = remaining
 	}
 	return collision
 }
 
+func (s *Sim) PrintCrashReport() {
+	fmt.Printf("%d crashes:\n", len(s.crashes))
+	for _, crash := range s.crashes {
+		fmt.Printf("  tick %5d @ %3d,%3d: cart %d and cart %d\n", crash.tick, crash.x, crash.y, crash.carts[0], crash.carts[1])
+	}
+}
+
 func DrawTrackSegment(imd *imdraw.IMDraw, x, y float64, size float64, shape Track) {
 	switch(shape) {
 	case Horizontal:
@@ -357,6 +437,13 @@ func DrawCarts(carts []*Cart, size int) *imdraw.IMDraw {
 func entry() {
 	interactive := flag.Bool("interactive", false, "Run GUI in step-by-step mode")
 	inputFile := flag.String("file", "day13_input.txt", "The input file")
+	collisions := flag.String("collisions", "remove", "What happens to carts that crash: remove, stop or bounce")
+	policy := flag.String("policy", "cyclic", "Turn policy at intersections: cyclic, random or scripted")
+	seed := flag.Int64("seed", 1, "Random seed for the random turn policy")
+	scripts := flag.String("script", "LSR", "Comma separated turn scripts (e.g. LSR,RRL) for the scripted turn policy")
+	validate := flag.Bool("validate", false, "Check the track and carts for errors and print the track graph")
+	events := flag.Bool("events", false, "Use the event driven simulation (only supports -collisions remove)")
+	maxTicks := flag.Int("maxticks", 30000, "Give up after this many ticks")
 	flag.Parse()
 	
 	fmt.Println("Reading input from ", *inputFile)
@@ -364,6 +451,50 @@ func entry() {
 	fmt.Printf("Size of map: %dx%d\n", tracks.width, tracks.height)
 	fmt.Printf("Number of carts: %d\n", len(carts.carts))
 
+	if *validate {
+		errs := Validate(tracks, carts)
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		if len(errs) > 0 {
+			fmt.Printf("Found %d problems with the track\n", len(errs))
+			return
+		}
+		fmt.Println("Track is valid")
+		ExtractGraph(tracks).Print()
+		return
+	}
+
+	mode, err := ParseCollisionMode(*collisions)
+	if err != nil {
+		panic(err)
+	}
+	err = AssignPolicies(&carts, *policy, *seed, *scripts)
+	if err != nil {
+		panic(err)
+	}
+
+	if *events {
+		if mode != RemoveOnCollision {
+			panic("Event driven simulation only supports -collisions remove")
+		}
+		esim := NewEventSim(tracks, carts)
+		tick := esim.Run(*maxTicks)
+		if len(esim.crashes) > 0 {
+			first := esim.crashes[0]
+			fmt.Printf("First crash on tick %d @ %d,%d\n", first.tick, first.x, first.y)
+		}
+		if esim.alive == 1 {
+			_, x, y := esim.Survivor(tick)
+			fmt.Printf("Last cart remains at %d, %d after tick %d\n", x, y, tick)
+		} else {
+			fmt.Printf("%d carts still running after tick %d\n", esim.alive, tick)
+		}
+		return
+	}
+
+	sim := NewSim(tracks, carts, mode)
+
 	if *interactive {
 		// Create a UI for visualizing
 		cfg := pixelgl.WindowConfig{
@@ -382,10 +513,10 @@ func entry() {
 			if window.JustPressed(pixelgl.KeyEnter) {
 				tick += 1
 				fmt.Println("Iteration ", tick)
-				RunTick(tracks, &carts)
+				sim.RunTick()
 				window.Clear(pixel.RGB(1.0, 1.0, 1.0))
 				imd.Draw(window)
-				cartImd := DrawCarts(carts.carts, 1050)
+				cartImd := DrawCarts(sim.carts.carts, 1050)
 				cartImd.Draw(window)
 				window.Update()
 			} else {
@@ -394,17 +525,21 @@ func entry() {
 			window.Update()
 		}
 	} else {
-		for tick := 0; tick < 30000; tick += 1 {
-			collision := RunTick(tracks, &carts)
-			if collision { fmt.Printf("Iteration: %d, carts remaining: %d\n", tick+1, len(carts.carts)) }
-			if len(carts.carts) == 1 {
-				//RunTick(tracks, &carts)
-				fmt.Printf("Last cart remains at %d, %d\n", carts.carts[0].x, carts.carts[0].y)
-				return
+		for sim.tick < *maxTicks {
+			collision := sim.RunTick()
+			if collision { fmt.Printf("Iteration: %d, carts remaining: %d\n", sim.tick, sim.Moving()) }
+			if mode == RemoveOnCollision && len(sim.carts.carts) == 1 {
+				fmt.Printf("Last cart remains at %d, %d\n", sim.carts.carts[0].x, sim.carts.carts[0].y)
+				break
+			}
+			if sim.Moving() == 0 {
+				fmt.Println("All carts have stopped")
+				break
 			}
 		}
+		sim.PrintCrashReport()
 	}
-	
+
 }
 
 func main() {
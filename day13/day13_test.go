@@ -0,0 +1,323 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/** Read a track drawn out line by line */
+func loadTrack(t *testing.T, lines ...string) (*Map, CartList) {
+	path := filepath.Join(t.TempDir(), "track.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ReadInput(path)
+}
+
+func takeTurns(p TurnPolicy, n int) []Turn {
+	turns := make([]Turn, n)
+	for i := range turns {
+		turns[i] = p.NextTurn()
+	}
+	return turns
+}
+
+func TestCyclicPolicy(t *testing.T) {
+	got := takeTurns(NewCyclicPolicy(), 7)
+	want := []Turn{TurnLeft, GoStraight, TurnRight, TurnLeft, GoStraight, TurnRight, TurnLeft}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Default cyclic turns %v, want %v", got, want)
+	}
+	got = takeTurns(NewCyclicPolicy(TurnRight, TurnRight, GoStraight), 4)
+	want = []Turn{TurnRight, TurnRight, GoStraight, TurnRight}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RRS cyclic turns %v, want %v", got, want)
+	}
+}
+
+func TestScriptedPolicy(t *testing.T) {
+	script, err := ParseTurns("lrR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := takeTurns(NewScriptedPolicy(script), 5)
+	want := []Turn{TurnLeft, TurnRight, TurnRight, GoStraight, GoStraight}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Scripted turns %v, want %v", got, want)
+	}
+	if _, err := ParseTurns("LXR"); err == nil {
+		t.Error("ParseTurns accepted an X")
+	}
+}
+
+func TestRandomPolicy(t *testing.T) {
+	a := takeTurns(NewRandomPolicy(7), 100)
+	if b := takeTurns(NewRandomPolicy(7), 100); !reflect.DeepEqual(a, b) {
+		t.Error("Random policies with the same seed took different turns")
+	}
+	seen := make(map[Turn]bool)
+	for _, turn := range a {
+		if turn < TurnLeft || turn > TurnRight {
+			t.Fatalf("Random turn %d out of range", turn)
+		}
+		seen[turn] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Only saw turns %v in 100 random turns", seen)
+	}
+}
+
+func TestAssignPolicies(t *testing.T) {
+	_, carts := ReadInput("day13_example2.txt")
+	if err := AssignPolicies(&carts, "scripted", 1, "L,RS"); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range carts.carts {
+		want := []Turn{TurnLeft, GoStraight}
+		if i % 2 == 1 {
+			want = []Turn{TurnRight, GoStraight}
+		}
+		if got := takeTurns(c.policy, 2); !reflect.DeepEqual(got, want) {
+			t.Errorf("Cart %d turns %v, want %v", i, got, want)
+		}
+	}
+	if err := AssignPolicies(&carts, "sideways", 1, ""); err == nil {
+		t.Error("AssignPolicies accepted an unknown policy")
+	}
+	if err := AssignPolicies(&carts, "scripted", 1, "LQ"); err == nil {
+		t.Error("AssignPolicies accepted a bad script")
+	}
+}
+
+// Two carts heading for each other along the top of a loop, which meet on
+// tick 2 when the first one moves onto (3, 0)
+var headOn = []string{
+	"/>--<\\",
+	"\\----/",
+}
+
+func TestRemoveOnCollision(t *testing.T) {
+	tracks, carts := loadTrack(t, headOn...)
+	sim := NewSim(tracks, carts, RemoveOnCollision)
+	if sim.RunTick() {
+		t.Fatal("Crash on tick 1")
+	}
+	if !sim.RunTick() {
+		t.Fatal("No crash on tick 2")
+	}
+	want := []Crash{{2, 3, 0, [2]int{0, 1}}}
+	if !reflect.DeepEqual(sim.crashes, want) {
+		t.Errorf("Crashes %v, want %v", sim.crashes, want)
+	}
+	if len(sim.carts.carts) != 0 {
+		t.Errorf("%d carts left on the track", len(sim.carts.carts))
+	}
+}
+
+func TestStopOnCollision(t *testing.T) {
+	tracks, carts := loadTrack(t, headOn...)
+	sim := NewSim(tracks, carts, StopOnCollision)
+	sim.RunTick()
+	sim.RunTick()
+	if sim.Moving() != 0 {
+		t.Errorf("%d carts still moving", sim.Moving())
+	}
+	for _, c := range sim.carts.carts {
+		if c.x != 3 || c.y != 0 {
+			t.Errorf("Cart %d stopped at %d,%d, want 3,0", c.id, c.x, c.y)
+		}
+	}
+	sim.RunTick()
+	if len(sim.crashes) != 1 {
+		t.Errorf("%d crashes, want 1", len(sim.crashes))
+	}
+}
+
+func TestBounceOnCollision(t *testing.T) {
+	tracks, carts := loadTrack(t, headOn...)
+	sim := NewSim(tracks, carts, BounceOnCollision)
+	sim.RunTick()
+	sim.RunTick()
+	want := []Crash{{2, 3, 0, [2]int{0, 1}}}
+	if !reflect.DeepEqual(sim.crashes, want) {
+		t.Errorf("Crashes %v, want %v", sim.crashes, want)
+	}
+	// Both carts head back the way they came, the second one setting off
+	// in the same tick since it moves after the first
+	sim.RunTick()
+	positions := map[int][3]int{}
+	for _, c := range sim.carts.carts {
+		positions[c.id] = [3]int{c.x, c.y, int(c.dir)}
+	}
+	wantPositions := map[int][3]int{0: {1, 0, int(Left)}, 1: {5, 0, int(Right)}}
+	if !reflect.DeepEqual(positions, wantPositions) {
+		t.Errorf("Carts at %v after bouncing, want %v", positions, wantPositions)
+	}
+}
+
+func TestBounceTakesBackTurn(t *testing.T) {
+	// The first cart reaches the intersection on tick 1, and tries to turn
+	// right into the second one on tick 2, which then heads back down
+	tracks, carts := loadTrack(t,
+		"  |  ",
+		"->+--",
+		"  |  ",
+		"  ^  ",
+		"  |  ",
+	)
+	carts.carts[0].policy = NewScriptedPolicy([]Turn{TurnRight, TurnLeft})
+	sim := NewSim(tracks, carts, BounceOnCollision)
+	sim.RunTick()
+	sim.RunTick()
+	c, other := carts.carts[0], carts.carts[1]
+	if c.x != 2 || c.y != 1 || other.x != 2 || other.y != 3 {
+		t.Fatalf("Carts at %d,%d and %d,%d, want 2,1 and 2,3", c.x, c.y, other.x, other.y)
+	}
+	sim.RunTick()
+	if c.x != 1 || c.y != 1 || other.x != 2 || other.y != 4 {
+		t.Fatalf("Carts at %d,%d and %d,%d after bouncing, want 1,1 and 2,4", c.x, c.y, other.x, other.y)
+	}
+	// The right turn was taken back, so it's still the next one due
+	if !c.replay || c.turn != TurnRight {
+		t.Errorf("Cart's next turn is %s (replay %t), want R", c.turn, c.replay)
+	}
+}
+
+func TestBounceNeverSharesCells(t *testing.T) {
+	tracks, carts := ReadInput("day13_input.txt")
+	if err := AssignPolicies(&carts, "scripted", 1, "LSR,RRL,SLS"); err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSim(tracks, carts, BounceOnCollision)
+	for sim.tick < 5000 {
+		sim.RunTick()
+		occupied := make(map[[2]int]int)
+		for _, c := range sim.carts.carts {
+			if id, ok := occupied[[2]int{c.x, c.y}]; ok {
+				t.Fatalf("Tick %d: carts %d and %d both at %d,%d", sim.tick, id, c.id, c.x, c.y)
+			}
+			occupied[[2]int{c.x, c.y}] = c.id
+		}
+	}
+	if len(sim.crashes) == 0 {
+		t.Error("No crashes in 5000 ticks")
+	}
+}
+
+/** Run the tick by tick simulation until one cart is left, or maxTicks */
+func runTicks(t *testing.T, file string, maxTicks int) *Sim {
+	tracks, carts := ReadInput(file)
+	sim := NewSim(tracks, carts, RemoveOnCollision)
+	for sim.tick < maxTicks && len(sim.carts.carts) > 1 {
+		sim.RunTick()
+	}
+	return sim
+}
+
+func TestEventSimMatchesTicks(t *testing.T) {
+	tracks, carts := ReadInput("day13_input.txt")
+	esim := NewEventSim(tracks, carts)
+	tick := esim.Run(30000)
+	sim := runTicks(t, "day13_input.txt", 30000)
+
+	first := esim.crashes[0]
+	if first.tick != 124 || first.x != 64 || first.y != 57 {
+		t.Errorf("First crash on tick %d @ %d,%d, want tick 124 @ 64,57", first.tick, first.x, first.y)
+	}
+	if esim.alive != 1 {
+		t.Fatalf("%d carts left, want 1", esim.alive)
+	}
+	if _, x, y := esim.Survivor(tick); x != 136 || y != 8 {
+		t.Errorf("Last cart at %d,%d, want 136,8", x, y)
+	}
+	if last := sim.carts.carts[0]; last.x != 136 || last.y != 8 {
+		t.Errorf("Tick sim's last cart at %d,%d, want 136,8", last.x, last.y)
+	}
+	if !reflect.DeepEqual(esim.crashes, sim.crashes) {
+		t.Errorf("Event sim crashes %v, tick sim crashes %v", esim.crashes, sim.crashes)
+	}
+}
+
+func TestEventSimExample(t *testing.T) {
+	tracks, carts := ReadInput("day13_example2.txt")
+	esim := NewEventSim(tracks, carts)
+	tick := esim.Run(1000)
+	sim := runTicks(t, "day13_example2.txt", tick)
+	if !reflect.DeepEqual(esim.crashes, sim.crashes) {
+		t.Errorf("Event sim crashes %v, tick sim crashes %v", esim.crashes, sim.crashes)
+	}
+	if _, x, y := esim.Survivor(tick); x != 6 || y != 4 {
+		t.Errorf("Last cart at %d,%d, want 6,4", x, y)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		lines []string
+		cart *Cart // Put on the track after reading it
+		x int
+		y int
+		msg string
+	}{
+		{"bad character", []string{"/-x\\", "\\--/"}, nil, 2, 0, "unrecognised character"},
+		{"dangling segment", []string{"/--\\", "|  |", "\\- /"}, nil, 1, 2, "horizontal track has no connection right"},
+		{"ambiguous curve", []string{"+++", "+/+", "+++"}, nil, 1, 1, "right curve could be laid either way"},
+		{"cart on wrong track", []string{"/--\\", "\\--/"}, NewCart(0, 1, 0, Up), 1, 0, "cart 0 facing up is on incompatible track"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracks, carts := loadTrack(t, tc.lines...)
+			if tc.cart != nil {
+				carts.carts = append(carts.carts, tc.cart)
+			}
+			errs := Validate(tracks, carts)
+			for _, e := range errs {
+				if e.x == tc.x && e.y == tc.y && e.msg == tc.msg {
+					return
+				}
+			}
+			t.Errorf("No %q error at %d,%d in %v", tc.msg, tc.x, tc.y, errs)
+		})
+	}
+
+	tracks, carts := ReadInput("day13_example.txt")
+	if errs := Validate(tracks, carts); len(errs) != 0 {
+		t.Errorf("Example track has errors %v", errs)
+	}
+}
+
+func TestExtractGraph(t *testing.T) {
+	tracks, _ := ReadInput("day13_example.txt")
+	g := ExtractGraph(tracks)
+	wantNodes := [][2]int{{4, 2}, {7, 2}, {2, 4}, {9, 4}}
+	if !reflect.DeepEqual(g.nodes, wantNodes) {
+		t.Errorf("Nodes %v, want %v", g.nodes, wantNodes)
+	}
+	wantEdges := []Edge{
+		{[2]int{4, 2}, [2]int{2, 4}, Up, Right, 12},
+		{[2]int{4, 2}, [2]int{7, 2}, Right, Right, 3},
+		{[2]int{4, 2}, [2]int{2, 4}, Down, Left, 4},
+		{[2]int{4, 2}, [2]int{2, 4}, Left, Down, 4},
+		{[2]int{7, 2}, [2]int{9, 4}, Up, Left, 12},
+		{[2]int{7, 2}, [2]int{9, 4}, Right, Down, 4},
+		{[2]int{7, 2}, [2]int{9, 4}, Down, Right, 4},
+		{[2]int{2, 4}, [2]int{9, 4}, Down, Up, 9},
+	}
+	if !reflect.DeepEqual(g.edges, wantEdges) {
+		t.Errorf("Edges %v, want %v", g.edges, wantEdges)
+	}
+	if len(g.loops) != 0 {
+		t.Errorf("Loops %v, want none", g.loops)
+	}
+
+	// A loop with no intersections on it
+	tracks, _ = loadTrack(t, "/--\\", "|  |", "\\--/")
+	g = ExtractGraph(tracks)
+	if len(g.nodes) != 0 || len(g.edges) != 0 || !reflect.DeepEqual(g.loops, []Loop{{[2]int{0, 0}, 10}}) {
+		t.Errorf("Got nodes %v, edges %v and loops %v for a plain loop", g.nodes, g.edges, g.loops)
+	}
+}
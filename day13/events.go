@@ -0,0 +1,352 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+/* Event driven cart simulation
+ *
+ * Rather than stepping every cart every tick, each cart's path is broken into
+ * straight segments which run from one curve or intersection to the next.
+ * Along a segment a cart's position is a simple function of time, so for any
+ * pair of carts we can solve for the first tick at which one of them runs
+ * into the other. Those candidate collisions go in a priority queue along with
+ * the ticks at which carts reach the end of their segments, and we jump from
+ * one event to the next.
+ *
+ * Two carts can only meet on a cell both their segments cover, so each cart is
+ * filed under every cell of its current segment, and a new segment is only
+ * checked against the carts filed under its own cells.
+ *
+ * Each segment carries a version number, and events for segments which have
+ * since ended are left in the queue and dropped when they come up. Whenever
+ * the queue doubles in size it gets swept clean of them, so they can't pile
+ * up.
+ *
+ * This only models RemoveOnCollision, but it follows the same rules as
+ * RunTick: in each tick carts move one at a time in reading order, so a cart
+ * can crash into the spot another cart has just moved to, or into one which
+ * hasn't moved yet.
+ */
+
+type segment struct {
+	start int // Tick at which the cart is at (x, y)
+	x int
+	y int
+	dir CartDir
+	length int // Ticks until the cart reaches the next curve or intersection
+	version int
+}
+
+func (s *segment) pos(tick int) (int, int) {
+	n := tick - s.start
+	return s.x + dirStep[s.dir][0]*n, s.y + dirStep[s.dir][1]*n
+}
+
+const (
+	collisionEvent = 0
+	segmentEvent = 1
+)
+
+type event struct {
+	tick int
+	kind int
+	order int // Reading order of the moving cart before the tick
+	a int // The cart which moves; the only cart for segment events
+	b int // The cart it runs into
+	versions [2]int
+}
+
+// Events are stored by value so the queue doesn't churn the allocator. push
+// and pop append and truncate directly and use heap.Fix to restore the order,
+// which keeps events from being boxed through heap.Push and heap.Pop.
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	// Within a tick, collisions have to come before carts start new
+	// segments, and happen in the order the carts move
+	if q[i].tick != q[j].tick {
+		return q[i].tick < q[j].tick
+	}
+	if q[i].kind != q[j].kind {
+		return q[i].kind < q[j].kind
+	}
+	return q[i].order < q[j].order
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+func (q *eventQueue) push(e event) {
+	*q = append(*q, e)
+	heap.Fix(q, len(*q)-1)
+}
+
+func (q *eventQueue) pop() event {
+	old := *q
+	e := old[0]
+	n := len(old) - 1
+	old[0] = old[n]
+	*q = old[:n]
+	if n > 0 {
+		heap.Fix(q, 0)
+	}
+	return e
+}
+
+type EventSim struct {
+	tracks *Map
+	carts []*Cart
+	segs []segment
+	removed []bool
+	alive int
+	queue eventQueue
+	compactAt int // Queue length at which to sweep out stale events
+	crashes []Crash
+	cells [][]int // Carts whose current segment covers each cell, by readingOrder
+	seen []int // Stamp of the last startSegment which checked each cart
+	stamp int
+}
+
+func NewEventSim(tracks *Map, carts CartList) *EventSim {
+	s := &EventSim{
+		tracks: tracks,
+		carts: carts.carts,
+		segs: make([]segment, len(carts.carts)),
+		removed: make([]bool, len(carts.carts)),
+		alive: len(carts.carts),
+		cells: make([][]int, (tracks.width+1)*tracks.height),
+		seen: make([]int, len(carts.carts)),
+		compactAt: 64,
+	}
+	for i, c := range s.carts {
+		s.startSegment(i, 0, c.x, c.y, c.dir)
+	}
+	return s
+}
+
+/** Number of ticks to get from (x, y) to the next curve or intersection */
+func (s *EventSim) runLength(x, y int, d CartDir) int {
+	n := 0
+	for {
+		x += dirStep[d][0]
+		y += dirStep[d][1]
+		n += 1
+		t := s.tracks.At(x, y)
+		if t == Blank {
+			panic(fmt.Sprintf("Kart runs off the track @ (%d, %d)", x, y))
+		}
+		if t != Horizontal && t != Vertical {
+			return n
+		}
+	}
+}
+
+func (s *EventSim) readingOrder(x, y int) int {
+	return y*(s.tracks.width+1) + x
+}
+
+/** Cart i arrives at (x, y) travelling in direction dir at the given tick, and
+ * sets off along its next segment
+ */
+func (s *EventSim) startSegment(i, tick, x, y int, dir CartDir) {
+	c := s.carts[i]
+	c.x, c.y, c.dir = x, y, dir
+	exit := ExitDir(s.tracks, c)
+	seg := &s.segs[i]
+	if seg.version > 0 {
+		s.unfile(i)
+	}
+	*seg = segment{tick, x, y, exit, s.runLength(x, y, exit), seg.version + 1}
+	s.push(event{tick: tick + seg.length, kind: segmentEvent, a: i, versions: [2]int{seg.version, 0}})
+
+	s.stamp += 1
+	s.seen[i] = s.stamp
+	for n := 0; n <= seg.length; n++ {
+		cell := s.cellIndex(seg, tick+n)
+		for _, j := range s.cells[cell] {
+			if s.seen[j] != s.stamp {
+				s.seen[j] = s.stamp
+				s.predict(i, j)
+			}
+		}
+		s.cells[cell] = append(s.cells[cell], i)
+	}
+}
+
+func (s *EventSim) cellIndex(seg *segment, tick int) int {
+	x, y := seg.pos(tick)
+	return s.readingOrder(x, y)
+}
+
+/** Take cart i out of the cells of its current segment */
+func (s *EventSim) unfile(i int) {
+	seg := &s.segs[i]
+	for n := 0; n <= seg.length; n++ {
+		cell := s.cellIndex(seg, seg.start+n)
+		list := s.cells[cell]
+		for k, j := range list {
+			if j == i {
+				list[k] = list[len(list)-1]
+				s.cells[cell] = list[:len(list)-1]
+				break
+			}
+		}
+	}
+}
+
+/** Whether an event refers to a segment which has since ended, or a cart
+ * which has crashed
+ */
+func (s *EventSim) stale(e *event) bool {
+	if s.removed[e.a] || s.segs[e.a].version != e.versions[0] {
+		return true
+	}
+	return e.kind == collisionEvent && (s.removed[e.b] || s.segs[e.b].version != e.versions[1])
+}
+
+func (s *EventSim) push(e event) {
+	s.queue.push(e)
+	if s.queue.Len() < s.compactAt {
+		return
+	}
+	live := s.queue[:0]
+	for _, e := range s.queue {
+		if !s.stale(&e) {
+			live = append(live, e)
+		}
+	}
+	s.queue = live
+	heap.Init(&s.queue)
+	s.compactAt = 2*s.queue.Len() + 64
+}
+
+/** Solve a + dirA*(t - startA) == b + dirB*(t - startB - lag) for t along one
+ * axis. Returns whether there's a solution, and whether every t is one.
+ */
+func solveAxis(a, dirA, startA, b, dirB, startB, lag int) (t int, ok bool, any bool) {
+	coef := dirA - dirB
+	rhs := b - a + dirA*startA - dirB*(startB+lag)
+	if coef == 0 {
+		return 0, rhs == 0, rhs == 0
+	}
+	if rhs % coef != 0 {
+		return 0, false, false
+	}
+	return rhs / coef, true, false
+}
+
+/** First tick in [lo, hi] at which cart a is where cart b was lag ticks
+ * earlier
+ */
+func meetTick(a, b *segment, lag, lo, hi int) (int, bool) {
+	tx, okX, anyX := solveAxis(a.x, dirStep[a.dir][0], a.start, b.x, dirStep[b.dir][0], b.start, lag)
+	ty, okY, anyY := solveAxis(a.y, dirStep[a.dir][1], a.start, b.y, dirStep[b.dir][1], b.start, lag)
+	if !okX || !okY {
+		return 0, false
+	}
+	t := lo
+	if !anyX {
+		t = tx
+	}
+	if !anyY {
+		if !anyX && ty != tx {
+			return 0, false
+		}
+		t = ty
+	}
+	return t, t >= lo && t <= hi
+}
+
+/** Queue up any collision between carts i and j on their current segments */
+func (s *EventSim) predict(i, j int) {
+	a, b := &s.segs[i], &s.segs[j]
+	// Only look at moves both segments cover
+	lo := a.start + 1
+	if b.start + 1 > lo {
+		lo = b.start + 1
+	}
+	hi := a.start + a.length
+	if b.start + b.length < hi {
+		hi = b.start + b.length
+	}
+	if lo > hi {
+		return
+	}
+
+	push := func(tick, mover, victim int) {
+		mx, my := s.segs[mover].pos(tick - 1)
+		s.push(event{tick, collisionEvent, s.readingOrder(mx, my), mover, victim,
+			[2]int{s.segs[mover].version, s.segs[victim].version}})
+	}
+	orderAt := func(seg *segment, tick int) int {
+		x, y := seg.pos(tick)
+		return s.readingOrder(x, y)
+	}
+
+	// Both carts move onto the same spot; whichever moves second crashes
+	if t, ok := meetTick(a, b, 0, lo, hi); ok {
+		if orderAt(a, t-1) < orderAt(b, t-1) {
+			push(t, j, i)
+		} else {
+			push(t, i, j)
+		}
+	}
+	// One cart moves onto the other before the other has moved
+	if t, ok := meetTick(a, b, 1, lo, hi); ok && orderAt(a, t-1) < orderAt(b, t-1) {
+		push(t, i, j)
+	}
+	if t, ok := meetTick(b, a, 1, lo, hi); ok && orderAt(b, t-1) < orderAt(a, t-1) {
+		push(t, j, i)
+	}
+}
+
+/** Run until there's at most one cart left, or until maxTick. Returns the
+ * tick the simulation stopped on.
+ */
+func (s *EventSim) Run(maxTick int) int {
+	for s.queue.Len() > 0 {
+		e := s.queue.pop()
+		if e.tick > maxTick {
+			return maxTick
+		}
+		if s.stale(&e) {
+			continue
+		}
+		if e.kind == segmentEvent {
+			seg := s.segs[e.a]
+			x, y := seg.pos(e.tick)
+			s.startSegment(e.a, e.tick, x, y, seg.dir)
+			continue
+		}
+		x, y := s.segs[e.a].pos(e.tick)
+		s.crashes = append(s.crashes, Crash{e.tick, x, y, [2]int{s.carts[e.a].id, s.carts[e.b].id}})
+		s.unfile(e.a)
+		s.unfile(e.b)
+		s.removed[e.a] = true
+		s.removed[e.b] = true
+		s.alive -= 2
+		if s.alive <= 1 {
+			return e.tick
+		}
+	}
+	return maxTick
+}
+
+/** Position of the first cart still running at the given tick */
+func (s *EventSim) Survivor(tick int) (*Cart, int, int) {
+	for i, c := range s.carts {
+		if !s.removed[i] {
+			x, y := s.segs[i].pos(tick)
+			return c, x, y
+		}
+	}
+	return nil, 0, 0
+}
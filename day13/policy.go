@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+type Turn int
+const (
+	TurnLeft Turn = 0
+	GoStraight Turn = 1
+	TurnRight Turn = 2
+)
+
+func (t Turn) String() string {
+	return [...]string{"L", "S", "R"}[t]
+}
+
+// A TurnPolicy decides which way a cart goes each time it reaches an
+// intersection. Each cart owns its own policy instance, so policies are free
+// to keep per-cart state.
+type TurnPolicy interface {
+	NextTurn() Turn
+}
+
+/** Cycle through a fixed sequence of turns forever. The puzzle's carts use
+ * left, straight, right.
+ */
+type CyclicPolicy struct {
+	sequence []Turn
+	count int
+}
+
+func NewCyclicPolicy(sequence ...Turn) *CyclicPolicy {
+	if len(sequence) == 0 {
+		sequence = []Turn{TurnLeft, GoStraight, TurnRight}
+	}
+	return &CyclicPolicy{sequence, 0}
+}
+
+func (p *CyclicPolicy) NextTurn() Turn {
+	t := p.sequence[p.count % len(p.sequence)]
+	p.count += 1
+	return t
+}
+
+/** Pick a uniformly random turn at each intersection */
+type RandomPolicy struct {
+	rng *rand.Rand
+}
+
+func NewRandomPolicy(seed int64) *RandomPolicy {
+	return &RandomPolicy{rand.New(rand.NewSource(seed))}
+}
+
+func (p *RandomPolicy) NextTurn() Turn {
+	return Turn(p.rng.Intn(3))
+}
+
+/** Play back a script of turns once, then go straight at every intersection
+ * after it runs out
+ */
+type ScriptedPolicy struct {
+	script []Turn
+	count int
+}
+
+func NewScriptedPolicy(script []Turn) *ScriptedPolicy {
+	return &ScriptedPolicy{script, 0}
+}
+
+func (p *ScriptedPolicy) NextTurn() Turn {
+	if p.count >= len(p.script) {
+		return GoStraight
+	}
+	t := p.script[p.count]
+	p.count += 1
+	return t
+}
+
+/** Parse a turn script such as "LSRR" */
+func ParseTurns(s string) ([]Turn, error) {
+	turns := make([]Turn, 0, len(s))
+	for _, c := range strings.ToUpper(s) {
+		switch c {
+		case 'L':
+			turns = append(turns, TurnLeft)
+		case 'S':
+			turns = append(turns, GoStraight)
+		case 'R':
+			turns = append(turns, TurnRight)
+		default:
+			return nil, fmt.Errorf("Invalid turn '%c' in script %q", c, s)
+		}
+	}
+	return turns, nil
+}
+
+/** Assign a fresh policy to every cart
+ *
+ * kind is one of "cyclic", "random" or "scripted". Random carts are seeded
+ * with seed + their index so a run is reproducible. For scripted carts,
+ * scripts is a comma separated list of turn scripts, and cart i gets
+ * script i modulo the number of scripts.
+ */
+func AssignPolicies(carts *CartList, kind string, seed int64, scripts string) error {
+	var parsed [][]Turn
+	if kind == "scripted" {
+		for _, s := range strings.Split(scripts, ",") {
+			turns, err := ParseTurns(s)
+			if err != nil {
+				return err
+			}
+			parsed = append(parsed, turns)
+		}
+	}
+	for i, c := range carts.carts {
+		switch kind {
+		case "cyclic":
+			c.policy = NewCyclicPolicy()
+		case "random":
+			c.policy = NewRandomPolicy(seed + int64(i))
+		case "scripted":
+			c.policy = NewScriptedPolicy(parsed[i % len(parsed)])
+		default:
+			return fmt.Errorf("Unknown turn policy %q", kind)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Openings are stored as a bitmask with bit (1 << dir) set for each direction
+// a piece of track leads out of its location
+type Openings uint8
+
+const AllOpenings Openings = 0xF
+
+func (o Openings) Has(d CartDir) bool {
+	return o & (1 << uint(d)) != 0
+}
+
+func openingsOf(dirs ...CartDir) Openings {
+	var o Openings
+	for _, d := range dirs {
+		o |= 1 << uint(d)
+	}
+	return o
+}
+
+// The two ways a curve can be laid, e.g. '/' is either the top-left corner of
+// a loop (leading right and down) or the bottom-right (leading up and left)
+var curveOptions = map[Track][2]Openings{
+	RightCurve: {openingsOf(Right, Down), openingsOf(Up, Left)},
+	LeftCurve: {openingsOf(Left, Down), openingsOf(Up, Right)},
+}
+
+/** The directions a piece of track could possibly lead, before working out
+ * which way its curves are laid
+ */
+func possibleOpenings(t Track) Openings {
+	switch t {
+	case Horizontal:
+		return openingsOf(Left, Right)
+	case Vertical:
+		return openingsOf(Up, Down)
+	case RightCurve, LeftCurve, Intersection:
+		return AllOpenings
+	}
+	return 0
+}
+
+/** Get the track at a location, treating anything off the map as blank */
+func (m *Map) At(x, y int) Track {
+	if x < 0 || y < 0 || x >= m.width || y >= m.height {
+		return Blank
+	}
+	return m.locs[y][x]
+}
+
+type ValidationError struct {
+	x int
+	y int
+	msg string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("(%d, %d): %s", e.x, e.y, e.msg)
+}
+
+/** Work out which directions each piece of track leads in. Curves are laid
+ * whichever way their neighbours support. Returns the openings of every
+ * location along with any curves that couldn't be resolved.
+ */
+func ResolveOpenings(m *Map) ([][]Openings, []ValidationError) {
+	errs := make([]ValidationError, 0)
+	openings := make([][]Openings, m.height)
+	for y := 0; y < m.height; y++ {
+		openings[y] = make([]Openings, m.width)
+		for x := 0; x < m.width; x++ {
+			t := m.Get(x, y)
+			options, isCurve := curveOptions[t]
+			if !isCurve {
+				openings[y][x] = possibleOpenings(t)
+				continue
+			}
+			// Score each way of laying the curve; a neighbour which must
+			// connect (i.e. isn't itself a curve) is worth more than one
+			// which only might
+			scores := [2]int{}
+			for i, option := range options {
+				for d := Up; d <= Left; d++ {
+					if !option.Has(d) {
+						continue
+					}
+					n := m.At(x + dirStep[d][0], y + dirStep[d][1])
+					if !possibleOpenings(n).Has(Reverse(d)) {
+						scores[i] = -1
+						break
+					}
+					if _, neighbourIsCurve := curveOptions[n]; neighbourIsCurve {
+						scores[i] += 1
+					} else {
+						scores[i] += 2
+					}
+				}
+			}
+			if scores[0] < 0 && scores[1] < 0 {
+				errs = append(errs, ValidationError{x, y, fmt.Sprintf("%s does not connect either way", t)})
+			} else if scores[0] == scores[1] {
+				errs = append(errs, ValidationError{x, y, fmt.Sprintf("%s could be laid either way", t)})
+			} else if scores[0] > scores[1] {
+				openings[y][x] = options[0]
+			} else {
+				openings[y][x] = options[1]
+			}
+		}
+	}
+	return openings, errs
+}
+
+/** Check that every track segment connects to its neighbours, and that every
+ * cart starts on track which will carry it the way it's facing
+ */
+func Validate(m *Map, carts CartList) []ValidationError {
+	errs := make([]ValidationError, 0)
+	for _, loc := range m.unknown {
+		errs = append(errs, ValidationError{loc[0], loc[1], "unrecognised character"})
+	}
+
+	openings, curveErrs := ResolveOpenings(m)
+	errs = append(errs, curveErrs...)
+
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			for d := Up; d <= Left; d++ {
+				if !openings[y][x].Has(d) {
+					continue
+				}
+				nx, ny := x + dirStep[d][0], y + dirStep[d][1]
+				if m.At(nx, ny) == Blank || !openings[ny][nx].Has(Reverse(d)) {
+					errs = append(errs, ValidationError{x, y, fmt.Sprintf("%s track has no connection %s", m.Get(x, y), d)})
+				}
+			}
+		}
+	}
+
+	for _, c := range carts.carts {
+		o := openings[c.y][c.x]
+		if !o.Has(c.dir) || !o.Has(Reverse(c.dir)) {
+			errs = append(errs, ValidationError{c.x, c.y, fmt.Sprintf("cart %d facing %s is on incompatible track", c.id, c.dir)})
+		}
+	}
+	return errs
+}
+
+type Edge struct {
+	from [2]int
+	to [2]int
+	fromDir CartDir // Direction the edge leaves its from intersection
+	toDir CartDir // Direction the edge arrives at its to intersection
+	length int
+}
+
+// A closed loop of track with no intersections on it
+type Loop struct {
+	start [2]int
+	length int
+}
+
+type TrackGraph struct {
+	nodes [][2]int
+	edges []Edge
+	loops []Loop
+}
+
+/** Follow the track from a location in a direction until reaching an
+ * intersection, or coming back to where we started. Calls visit on each
+ * location passed through along the way.
+ */
+func followTrack(m *Map, x, y int, d CartDir, visit func(x, y int)) (int, int, CartDir, int) {
+	startX, startY := x, y
+	length := 0
+	for {
+		x += dirStep[d][0]
+		y += dirStep[d][1]
+		length += 1
+		t := m.Get(x, y)
+		if t == Intersection || (x == startX && y == startY) {
+			return x, y, d, length
+		}
+		visit(x, y)
+		if _, isCurve := curveTable[t]; isCurve {
+			d = curveTable[t][d]
+		}
+	}
+}
+
+/** Extract the track network as a graph of intersections joined by edges.
+ * Assumes the map passes Validate.
+ */
+func ExtractGraph(m *Map) *TrackGraph {
+	var g TrackGraph
+	openings, _ := ResolveOpenings(m)
+	visited := make(map[[2]int]bool)
+	markVisited := func(x, y int) { visited[[2]int{x, y}] = true }
+
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			if m.Get(x, y) != Intersection {
+				continue
+			}
+			from := [2]int{x, y}
+			g.nodes = append(g.nodes, from)
+			for d := Up; d <= Left; d++ {
+				tx, ty, arriveDir, length := followTrack(m, x, y, d, markVisited)
+				to := [2]int{tx, ty}
+				// Every edge gets walked once from each end; only keep the
+				// walk which starts from the lower of the two ends
+				back := Reverse(arriveDir)
+				if to[1] < from[1] || (to[1] == from[1] && to[0] < from[0]) || (to == from && back < d) {
+					continue
+				}
+				g.edges = append(g.edges, Edge{from, to, d, arriveDir, length})
+			}
+		}
+	}
+
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			if m.Get(x, y) == Blank || visited[[2]int{x, y}] || m.Get(x, y) == Intersection {
+				continue
+			}
+			// Leave along any opening; the loop brings us back here
+			d := Up
+			for !openings[y][x].Has(d) {
+				d += 1
+			}
+			markVisited(x, y)
+			_, _, _, length := followTrack(m, x, y, d, markVisited)
+			g.loops = append(g.loops, Loop{[2]int{x, y}, length})
+		}
+	}
+	return &g
+}
+
+func (g *TrackGraph) Print() {
+	total := 0
+	for _, e := range g.edges {
+		total += e.length
+	}
+	fmt.Printf("Track graph: %d intersections, %d edges, %d loops without intersections\n", len(g.nodes), len(g.edges), len(g.loops))
+	fmt.Printf("Total edge length: %d\n", total)
+	for _, e := range g.edges {
+		fmt.Printf("  (%d, %d) %-5s -> (%d, %d) %-5s length %d\n", e.from[0], e.from[1], e.fromDir, e.to[0], e.to[1], e.toDir, e.length)
+	}
+	for _, l := range g.loops {
+		fmt.Printf("  loop from (%d, %d) length %d\n", l.start[0], l.start[1], l.length)
+	}
+}
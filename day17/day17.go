@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"regexp"
@@ -16,19 +17,73 @@ const (
 	FlowingWater
 )
 
-type DirtMap struct {
+// DirtStore holds the contents of each tile. Anything never set is Sand.
+type DirtStore interface {
+	Get(x, y int) DirtType
+	Set(x, y int, dtype DirtType)
+	// Return the number of water tiles, and how many of those are static
+	CountWater() (int, int)
+}
+
+// mapStore keeps only the tiles which aren't sand, so it needs no bounds
+type mapStore struct {
 	clayMap map[[2]int]bool
 	waterMap map[[2]int]bool
+}
+
+func newMapStore() *mapStore {
+	var ms mapStore
+	ms.clayMap = make(map[[2]int]bool)
+	ms.waterMap = make(map[[2]int]bool)
+	return &ms
+}
+
+func (ms *mapStore) Get(x, y int) DirtType {
+	
+	if static, present := ms.waterMap[[2]int{x, y}]; present {
+		if static {
+			return StaticWater
+		} else {
+			return FlowingWater
+		}
+	}
+	if _, present := ms.clayMap[[2]int{x, y}]; present {
+		return Clay
+	}
+	return Sand
+}
+
+func (ms *mapStore) Set(x int, y int, dtype DirtType) {
+	switch dtype {
+	case Clay:
+		ms.clayMap[[2]int{x, y}] = true
+	case FlowingWater:
+		ms.waterMap[[2]int{x, y}] = false
+	case StaticWater:
+		ms.waterMap[[2]int{x, y}] = true
+	}
+}
+
+func (ms *mapStore) CountWater() (int, int) {
+	staticCount := 0
+	for _, w := range ms.waterMap {
+		if w {staticCount++}
+	}
+	return len(ms.waterMap), staticCount
+}
+
+type DirtMap struct {
+	store DirtStore
 	minX int
 	maxX int
 	minY int
 	maxY int
+	springs []Position
 }
 
 func NewDirtMap() *DirtMap {
 	var dm DirtMap
-	dm.clayMap = make(map[[2]int]bool)
-	dm.waterMap = make(map[[2]int]bool)
+	dm.store = newMapStore()
 	dm.minX = 1000000000
 	dm.maxX = 0
 	dm.minY = 1000000000
@@ -37,24 +92,11 @@ func NewDirtMap() *DirtMap {
 }
 
 func (dm DirtMap) Get(x, y int) DirtType {
-	
-	if static, present := dm.waterMap[[2]int{x, y}]; present {
-		if static {
-			return StaticWater
-		} else {
-			return FlowingWater
-		}
-	}
-	if _, present := dm.clayMap[[2]int{x, y}]; present {
-		return Clay
-	}
-	return Sand
+	return dm.store.Get(x, y)
 }
 
 func (dm *DirtMap) Set(x int, y int, dtype DirtType) {
-	switch dtype {
-	case Clay:
-		dm.clayMap[[2]int{x, y}] = true
+	if dtype == Clay {
 		if y < dm.minY {
 			dm.minY = y
 		}
@@ -67,11 +109,12 @@ func (dm *DirtMap) Set(x int, y int, dtype DirtType) {
 		if x > dm.maxX {
 			dm.maxX = x
 		}
-	case FlowingWater:
-		dm.waterMap[[2]int{x, y}] = false
-	case StaticWater:
-		dm.waterMap[[2]int{x, y}] = true
 	}
+	dm.store.Set(x, y, dtype)
+}
+
+func (dm *DirtMap) CountWater() (int, int) {
+	return dm.store.CountWater()
 }
 
 func ReadInput(filepath string) *DirtMap {
@@ -83,7 +126,14 @@ func ReadInput(filepath string) *DirtMap {
 
 	dmap := NewDirtMap()
 	re := regexp.MustCompile("([xy])=([0-9]+), [xy]=([0-9]+)..([0-9]+)")
+	springRe := regexp.MustCompile("spring x=([0-9]+), y=([0-9]+)")
 	for scanner.Scan() {
+		if match := springRe.FindStringSubmatch(scanner.Text()); match != nil {
+			x, _ := strconv.Atoi(match[1])
+			y, _ := strconv.Atoi(match[2])
+			dmap.springs = append(dmap.springs, Position{x, y})
+			continue
+		}
 		match := re.FindStringSubmatch(scanner.Text())
 		if match == nil {
 			fmt.Println("Couldn't parse line: ", scanner.Text())
@@ -105,6 +155,10 @@ func ReadInput(filepath string) *DirtMap {
 			dmap.Set(x, y, Clay)
 		}
 	}
+	if len(dmap.springs) == 0 {
+		// The puzzle input doesn't list the spring, it's always here
+		dmap.springs = []Position{{500, 0}}
+	}
 	return dmap
 }
 
@@ -138,13 +192,32 @@ func FillWater(dm *DirtMap, x int, y int, delta int) (int, bool) {
 	return dx, leak
 }
 
-func DropWater(dm *DirtMap, x int, y int) []Position{
+type DropOutcome int
+const (
+	FellOff DropOutcome = iota // Fell past the bottom of the map
+	Merged // Landed in water which is already flowing
+	Filled // Filled up from where it landed until it spilled
+)
+
+// Drop records what happened to the water falling from one source
+type Drop struct {
+	source Position
+	landed Position // Last tile the water fell through
+	outcome DropOutcome
+	spills []Position // Where the water spilled over, i.e. new sources
+	parent int // Index of the drop this one spilled from, -1 for a spring
+}
+
+func DropWater(dm *DirtMap, x int, y int) Drop {
+	drop := Drop{source: Position{x, y}, spills: make([]Position, 0)}
 	// Drop until we hit something besides sand, marking each tile we fall 
 	// through as flowing water
 	for {
 		if y > dm.maxY {
 			// We fell of the map
-			return make([]Position, 0)
+			drop.landed = Position{x, y}
+			drop.outcome = FellOff
+			return drop
 		}
 		dtypeBelow := dm.Get(x, y+1)
 		if y < dm.minY {
@@ -157,7 +230,9 @@ func DropWater(dm *DirtMap, x int, y int) []Position{
 			// We landed on an already overflowed container
 			// We need not continue
 			dm.Set(x, y, FlowingWater)
-			return make([]Position, 0)	
+			drop.landed = Position{x, y}
+			drop.outcome = Merged
+			return drop
 		}else {
 			break
 		}
@@ -165,6 +240,8 @@ func DropWater(dm *DirtMap, x int, y int) []Position{
 	}
 
 	// We landed on something solid. Now keep filling until we overflow. 
+	drop.landed = Position{x, y}
+	drop.outcome = Filled
 	for {
 		// Seek left until obstructed, or until we find another drop
 		leftBound, leftLeak := FillWater(dm, x, y, -1)
@@ -181,14 +258,13 @@ func DropWater(dm *DirtMap, x int, y int) []Position{
 		}
 
 		if leak {
-			newDropSources := make([]Position, 0)
 			if leftLeak {
-				newDropSources = append(newDropSources, Position{leftBound, y})
+				drop.spills = append(drop.spills, Position{leftBound, y})
 			}
 			if rightLeak {
-				newDropSources = append(newDropSources, Position{rightBound, y})
+				drop.spills = append(drop.spills, Position{rightBound, y})
 			}
-			return newDropSources
+			return drop
 		} else {
 			// Move up and fill again
 			y--
@@ -196,32 +272,96 @@ func DropWater(dm *DirtMap, x int, y int) []Position{
 	}
 }
 
+// WaterSim runs the fill one drop source at a time, so it can be paused to
+// look at the state of the map part way through
+type WaterSim struct {
+	dm *DirtMap
+	dropSources []Position
+	sourceParents []int // Index in drops of the drop each source spilled from
+	drops []Drop
+	steps int
+}
+
+func NewWaterSim(dm *DirtMap) *WaterSim {
+	var ws WaterSim
+	ws.dm = dm
+	ws.dropSources = append([]Position{}, dm.springs...)
+	for range dm.springs {
+		ws.sourceParents = append(ws.sourceParents, -1)
+	}
+	return &ws
+}
+
+func (ws *WaterSim) Done() bool {
+	return len(ws.dropSources) == 0
+}
+
+/** Drop water from the next source. Returns false once there's nothing left
+ * to fill.
+ */
+func (ws *WaterSim) Step() bool {
+	if ws.Done() {
+		return false
+	}
+	nextSource := ws.dropSources[0]
+	ws.dropSources = ws.dropSources[1:]
+	drop := DropWater(ws.dm, nextSource[0], nextSource[1])
+	drop.parent = ws.sourceParents[0]
+	ws.sourceParents = ws.sourceParents[1:]
+	ws.drops = append(ws.drops, drop)
+	// Each iteration may return 0 to 2 new drop locations to iterate on
+	ws.dropSources = append(ws.dropSources, drop.spills...)
+	for range drop.spills {
+		ws.sourceParents = append(ws.sourceParents, len(ws.drops)-1)
+	}
+	ws.steps++
+	return !ws.Done()
+}
+
 func main() {
-	dirtMap := ReadInput("day17_input.txt")
+	inputFile := flag.String("file", "day17_input.txt", "The input file")
+	pngFile := flag.String("png", "", "Write an image of the filled map to this PNG file")
+	gifFile := flag.String("gif", "", "Write an animation of the fill to this GIF file")
+	frameEvery := flag.Int("frameevery", 5, "Simulation steps between GIF frames")
+	scale := flag.Int("scale", 1, "Pixels per tile in PNG and GIF output")
+	backend := flag.String("backend", "dense", "Storage for the map: dense or map")
+	reservoirs := flag.Bool("reservoirs", false, "Print the basins and where they spill to")
+	jsonFile := flag.String("json", "", "Write the basins and where they spill to this JSON file")
+	flag.Parse()
+	if *frameEvery < 1 {
+		panic(fmt.Sprintf("-frameevery must be at least 1, not %d", *frameEvery))
+	}
+
+	dirtMap := ReadInput(*inputFile)
+	switch *backend {
+	case "dense":
+		dirtMap.UseDenseGrid()
+	case "map":
+	default:
+		panic("Unknown backend " + *backend)
+	}
 
 	fmt.Printf("Read map with x %d..%d and y %d..%d\n", dirtMap.minX, dirtMap.maxX, dirtMap.minY, dirtMap.maxY)
+	fmt.Printf("Springs at %v\n", dirtMap.springs)
 
-	dropSources := []Position{{500, 0}}
-	iterCount := 0
-	for len(dropSources) > 0 {
-		nextSource := dropSources[0]
-		dropSources = dropSources[1:]
-		// Each iteration may return 0 to 2 new drop locations to iterate on
-		newDropSources := DropWater(dirtMap, nextSource[0], nextSource[1])
-		if len(newDropSources) > 0 {
-			dropSources = append(dropSources, newDropSources...)
+	var anim *GifRecorder
+	if *gifFile != "" {
+		anim = NewGifRecorder(*scale)
+		anim.AddFrame(dirtMap)
+	}
+
+	sim := NewWaterSim(dirtMap)
+	for sim.Step() {
+		if sim.steps % 100 == 0 {
+			fmt.Println("Iteration: ", sim.steps, "(", len(sim.dropSources), ")")
 		}
-		iterCount++
-		if iterCount % 100 == 0 {
-			fmt.Println("Iteration: ", iterCount, "(", len(dropSources), ")")
+		if anim != nil && sim.steps % *frameEvery == 0 {
+			anim.AddFrame(dirtMap)
 		}
 	}
 
-	fmt.Printf("Found %d water tiles\n", len(dirtMap.waterMap))
-	staticCount := 0
-	for _, w := range dirtMap.waterMap {
-		if w {staticCount++}
-	}
+	waterCount, staticCount := dirtMap.CountWater()
+	fmt.Printf("Found %d water tiles\n", waterCount)
 	fmt.Printf("Found %d static water tiles\n", staticCount)
 	waterCnt := 0
 	for row := dirtMap.minY-2; row <= dirtMap.maxY+2; row++ {
@@ -241,4 +381,33 @@ func main() {
 		}
 		fmt.Printf("\n")
 	}
+
+	if *reservoirs || *jsonFile != "" {
+		report := AnalyseReservoirs(sim)
+		if *reservoirs {
+			report.Print()
+		}
+		if *jsonFile != "" {
+			if err := report.WriteJSON(*jsonFile); err != nil {
+				panic(err)
+			}
+			fmt.Println("Wrote", *jsonFile)
+		}
+	}
+
+	if anim != nil {
+		// Hold on the finished map for a while at the end
+		anim.AddFrame(dirtMap)
+		anim.delays[len(anim.delays)-1] = 300
+		if err := anim.Write(*gifFile); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Wrote %d frames to %s\n", len(anim.frames), *gifFile)
+	}
+	if *pngFile != "" {
+		if err := WritePNG(*pngFile, dirtMap.Image(*scale)); err != nil {
+			panic(err)
+		}
+		fmt.Println("Wrote", *pngFile)
+	}
 }
\ No newline at end of file
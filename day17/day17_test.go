@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/** Read a map from scan lines written out to a temporary file */
+func loadScan(t *testing.T, lines ...string) *DirtMap {
+	path := filepath.Join(t.TempDir(), "scan.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ReadInput(path)
+}
+
+/** Fill the map, and return the water and static water counts */
+func fill(dm *DirtMap) (int, int) {
+	sim := NewWaterSim(dm)
+	for sim.Step() {
+	}
+	return dm.CountWater()
+}
+
+/** Fill a map on each backend, checking they agree on the counts */
+func fillBoth(t *testing.T, load func() *DirtMap) (int, int) {
+	total, static := fill(load())
+	dense := load()
+	dense.UseDenseGrid()
+	denseTotal, denseStatic := fill(dense)
+	if denseTotal != total || denseStatic != static {
+		t.Errorf("Dense grid found %d water tiles, %d static; map found %d, %d", denseTotal, denseStatic, total, static)
+	}
+	return total, static
+}
+
+func TestBackendsAgree(t *testing.T) {
+	total, static := fillBoth(t, func() *DirtMap { return ReadInput("day17_input.txt") })
+	if total != 29063 || static != 23811 {
+		t.Errorf("Found %d water tiles, %d static; want 29063, 23811", total, static)
+	}
+	total, static = fillBoth(t, func() *DirtMap { return ReadInput("day17_example.txt") })
+	if total != 57 || static != 29 {
+		t.Errorf("Example found %d water tiles, %d static; want 57, 29", total, static)
+	}
+}
+
+func TestSpillOffGrid(t *testing.T) {
+	cases := []struct {
+		name string
+		lines []string
+	}{
+		// The box fills to the brim, so water runs over its top at the
+		// row above the highest clay
+		{"over the top", []string{
+			"x=495, y=1..3",
+			"x=501, y=1..3",
+			"y=3, x=495..501",
+			"spring x=498, y=0",
+		}},
+		// A spring well off to the side of all the clay, and one above
+		// a ledge at the edge of it
+		{"off the side", []string{
+			"y=5, x=500..502",
+			"x=502, y=8..10",
+			"spring x=490, y=0",
+			"spring x=501, y=0",
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			total, _ := fillBoth(t, func() *DirtMap { return loadScan(t, tc.lines...) })
+			if total == 0 {
+				t.Error("No water")
+			}
+		})
+	}
+}
+
+/** Time a complete fill of the puzzle input with each backend */
+func BenchmarkFill(b *testing.B) {
+	for _, backend := range []string{"map", "dense"} {
+		b.Run(backend, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dm := ReadInput("day17_input.txt")
+				if backend == "dense" {
+					dm.UseDenseGrid()
+				}
+				b.StartTimer()
+				sim := NewWaterSim(dm)
+				for sim.Step() {
+				}
+			}
+		})
+	}
+}
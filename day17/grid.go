@@ -0,0 +1,97 @@
+package main
+
+// gridStore is a dense array of tiles covering a region. Get is a bounds check
+// and an index, instead of a pair of map lookups. Setting a tile outside the
+// region grows it.
+type gridStore struct {
+	x0 int
+	y0 int
+	width int
+	height int
+	cells []DirtType
+}
+
+func newGridStore(x0, y0, width, height int) *gridStore {
+	return &gridStore{x0, y0, width, height, make([]DirtType, width*height)}
+}
+
+func (gs *gridStore) Get(x, y int) DirtType {
+	x -= gs.x0
+	y -= gs.y0
+	if x < 0 || y < 0 || x >= gs.width || y >= gs.height {
+		return Sand
+	}
+	return gs.cells[y*gs.width + x]
+}
+
+func (gs *gridStore) Set(x, y int, dtype DirtType) {
+	if x < gs.x0 || y < gs.y0 || x >= gs.x0 + gs.width || y >= gs.y0 + gs.height {
+		if dtype == Sand {
+			return
+		}
+		gs.grow(x, y)
+	}
+	gs.cells[(y - gs.y0)*gs.width + x - gs.x0] = dtype
+}
+
+/** Reallocate the grid to take in (x, y), with some room to spare on the side
+ * it grew so water spreading a tile at a time doesn't copy it every time
+ */
+func (gs *gridStore) grow(x, y int) {
+	x0, y0, x1, y1 := gs.x0, gs.y0, gs.x0 + gs.width, gs.y0 + gs.height
+	if x < x0 {
+		x0 = x - 8
+	}
+	if x >= x1 {
+		x1 = x + 9
+	}
+	if y < y0 {
+		y0 = y - 8
+	}
+	if y >= y1 {
+		y1 = y + 9
+	}
+	grown := newGridStore(x0, y0, x1 - x0, y1 - y0)
+	for row := 0; row < gs.height; row++ {
+		start := (row + gs.y0 - y0)*grown.width + gs.x0 - x0
+		copy(grown.cells[start:start + gs.width], gs.cells[row*gs.width:(row + 1)*gs.width])
+	}
+	*gs = *grown
+}
+
+func (gs *gridStore) CountWater() (int, int) {
+	total, static := 0, 0
+	for _, c := range gs.cells {
+		if c == StaticWater {
+			static++
+		}
+		if c == StaticWater || c == FlowingWater {
+			total++
+		}
+	}
+	return total, static
+}
+
+/** Switch the map over to a dense grid sized to the clay read so far and the
+ * springs, with a margin of one column on each side for water spilling past
+ * the outermost clay. Water which gets further out than that grows the grid.
+ */
+func (dm *DirtMap) UseDenseGrid() {
+	x0, x1 := dm.minX, dm.maxX
+	for _, spring := range dm.springs {
+		if spring[0] < x0 {
+			x0 = spring[0]
+		}
+		if spring[0] > x1 {
+			x1 = spring[0]
+		}
+	}
+	x0, x1 = x0-1, x1+1
+	grid := newGridStore(x0, dm.minY, x1 - x0 + 1, dm.maxY - dm.minY + 1)
+	for y := dm.minY; y <= dm.maxY; y++ {
+		for x := x0; x <= x1; x++ {
+			grid.Set(x, y, dm.store.Get(x, y))
+		}
+	}
+	dm.store = grid
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+)
+
+// Palette indices line up with DirtType, with the spring colour after them
+var dirtPalette = color.Palette{
+	Sand: color.RGBA{0xe8, 0xd8, 0xa8, 0xff},
+	Clay: color.RGBA{0x8b, 0x5a, 0x2b, 0xff},
+	StaticWater: color.RGBA{0x1f, 0x4e, 0xc8, 0xff},
+	FlowingWater: color.RGBA{0x8c, 0xc8, 0xff, 0xff},
+	color.RGBA{0xe0, 0x20, 0x20, 0xff},
+}
+
+const springColor = uint8(FlowingWater) + 1
+
+/** The region of the map worth drawing: everything from the springs down to
+ * the lowest clay, with a column either side for water spilling off the edges
+ */
+func (dm *DirtMap) renderBounds() image.Rectangle {
+	top := dm.minY
+	for _, s := range dm.springs {
+		if s[1] < top {
+			top = s[1]
+		}
+	}
+	return image.Rect(dm.minX-1, top, dm.maxX+2, dm.maxY+1)
+}
+
+/** Draw the map with each tile scale pixels square */
+func (dm *DirtMap) Image(scale int) *image.Paletted {
+	bounds := dm.renderBounds()
+	img := image.NewPaletted(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale), dirtPalette)
+	fill := func(x, y int, c uint8) {
+		px := (x - bounds.Min.X) * scale
+		py := (y - bounds.Min.Y) * scale
+		for j := 0; j < scale; j++ {
+			for i := 0; i < scale; i++ {
+				img.SetColorIndex(px+i, py+j, c)
+			}
+		}
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			fill(x, y, uint8(dm.Get(x, y)))
+		}
+	}
+	for _, s := range dm.springs {
+		fill(s[0], s[1], springColor)
+	}
+	return img
+}
+
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
+
+// GifRecorder collects snapshots of the map as it fills
+type GifRecorder struct {
+	scale int
+	frames []*image.Paletted
+	delays []int // Per frame, in 100ths of a second
+}
+
+func NewGifRecorder(scale int) *GifRecorder {
+	return &GifRecorder{scale: scale}
+}
+
+func (r *GifRecorder) AddFrame(dm *DirtMap) {
+	r.frames = append(r.frames, dm.Image(r.scale))
+	r.delays = append(r.delays, 5)
+}
+
+func (r *GifRecorder) Write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gif.EncodeAll(f, &gif.GIF{Image: r.frames, Delay: r.delays})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A Basin is one connected body of static water
+type Basin struct {
+	Id int `json:"id"`
+	Capacity int `json:"capacity"`
+	Min Position `json:"min"`
+	Max Position `json:"max"`
+}
+
+// A FlowNode is the water falling from one source: a spring, or a point where
+// the basin above spilled over. Spills lists the flows it feeds in turn.
+type FlowNode struct {
+	Source Position `json:"source"`
+	Landed Position `json:"landed"`
+	Outcome string `json:"outcome"`
+	Basin int `json:"basin"` // -1 if it didn't end up over static water
+	Joins *Position `json:"joins,omitempty"` // Source of the flow a merged drop ran into
+	Spills []*FlowNode `json:"spills,omitempty"`
+}
+
+type ReservoirReport struct {
+	Basins []Basin `json:"basins"`
+	Springs []*FlowNode `json:"springs"`
+}
+
+/** Label every connected region of static water with a basin number, in
+ * reading order. Returns the labels and the basins.
+ */
+func LabelBasins(dm *DirtMap) (map[Position]int, []Basin) {
+	labels := make(map[Position]int)
+	basins := make([]Basin, 0)
+	for y := dm.minY; y <= dm.maxY; y++ {
+		for x := dm.minX-1; x <= dm.maxX+1; x++ {
+			start := Position{x, y}
+			if _, seen := labels[start]; seen || dm.Get(x, y) != StaticWater {
+				continue
+			}
+			basin := Basin{len(basins), 0, start, start}
+			labels[start] = basin.Id
+			queue := []Position{start}
+			for len(queue) > 0 {
+				p := queue[0]
+				queue = queue[1:]
+				basin.Capacity++
+				for i := 0; i < 2; i++ {
+					if p[i] < basin.Min[i] {
+						basin.Min[i] = p[i]
+					}
+					if p[i] > basin.Max[i] {
+						basin.Max[i] = p[i]
+					}
+				}
+				for _, d := range []Position{{0, -1}, {1, 0}, {0, 1}, {-1, 0}} {
+					n := Position{p[0] + d[0], p[1] + d[1]}
+					if _, seen := labels[n]; seen || dm.Get(n[0], n[1]) != StaticWater {
+						continue
+					}
+					labels[n] = basin.Id
+					queue = append(queue, n)
+				}
+			}
+			basins = append(basins, basin)
+		}
+	}
+	return labels, basins
+}
+
+/** The basin a filled drop sits over: either the water filled a basin from
+ * where it landed, or it landed on the surface of one which was already full.
+ * Returns -1 if it filled a ledge without holding any water.
+ */
+func filledBasin(labels map[Position]int, d Drop) int {
+	if b, ok := labels[d.landed]; ok {
+		return b
+	}
+	if b, ok := labels[Position{d.landed[0], d.landed[1]+1}]; ok {
+		return b
+	}
+	return -1
+}
+
+/** Find the drop whose water a merged drop ran into, by following the water
+ * down through the flow it landed on. If it comes to rest over a basin it
+ * joins the first drop which filled that basin. Otherwise it's running along
+ * a ledge or falling off the map, and joins the drop which spilled along that
+ * row or fell down that column. Returns -1 if no drop accounts for it.
+ */
+func mergedInto(dm *DirtMap, labels map[Position]int, drops []Drop, d Drop) int {
+	x, y := d.landed[0], d.landed[1]
+	for y <= dm.maxY && dm.Get(x, y+1) == FlowingWater {
+		y++
+	}
+	basin, filledIn := labels[Position{x, y+1}]
+	for i, other := range drops {
+		if filledIn {
+			if other.outcome == Filled && filledBasin(labels, other) == basin {
+				return i
+			}
+			continue
+		}
+		switch other.outcome {
+		case FellOff:
+			if other.source[0] == x && other.source[1] <= y {
+				return i
+			}
+		case Filled:
+			if len(other.spills) == 0 || other.spills[0][1] != y {
+				continue
+			}
+			// The spilling row runs unbroken from where the drop
+			// landed out to the point we reached
+			lo, hi := other.landed[0], x
+			if hi < lo {
+				lo, hi = hi, lo
+			}
+			spans := true
+			for col := lo; col <= hi; col++ {
+				if dm.Get(col, y) != FlowingWater {
+					spans = false
+					break
+				}
+			}
+			if spans {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+/** Build the tree of springs, the basins they fill and where those spill
+ * over to. Sources which haven't been dropped from yet show up as pending.
+ */
+func AnalyseReservoirs(ws *WaterSim) *ReservoirReport {
+	labels, basins := LabelBasins(ws.dm)
+
+	// The same point can be spilled onto by more than one basin, so the
+	// tree follows the drop each source came from rather than the position
+	nodes := make([]*FlowNode, len(ws.drops))
+	for i, d := range ws.drops {
+		node := &FlowNode{Source: d.source, Landed: d.landed, Basin: -1}
+		switch d.outcome {
+		case FellOff:
+			node.Outcome = "fell off"
+		case Merged:
+			node.Outcome = "merged"
+		case Filled:
+			node.Outcome = "filled"
+			node.Basin = filledBasin(labels, d)
+		}
+		nodes[i] = node
+	}
+	for i, d := range ws.drops {
+		if d.outcome != Merged {
+			continue
+		}
+		// Resolve the flow the water joined, and the basin that flow
+		// runs over
+		if j := mergedInto(ws.dm, labels, ws.drops, d); j >= 0 {
+			nodes[i].Joins = &ws.drops[j].source
+			nodes[i].Basin = nodes[j].Basin
+		}
+	}
+
+	report := &ReservoirReport{Basins: basins}
+	attach := func(parent int, node *FlowNode) {
+		if parent < 0 {
+			report.Springs = append(report.Springs, node)
+		} else {
+			nodes[parent].Spills = append(nodes[parent].Spills, node)
+		}
+	}
+	for i, d := range ws.drops {
+		attach(d.parent, nodes[i])
+	}
+	for k, source := range ws.dropSources {
+		attach(ws.sourceParents[k], &FlowNode{Source: source, Outcome: "pending", Basin: -1})
+	}
+	return report
+}
+
+func (r *ReservoirReport) printNode(node *FlowNode, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Printf("%s(%d, %d) ", indent, node.Source[0], node.Source[1])
+	if node.Joins != nil {
+		fmt.Printf("-> merged at (%d, %d) into the flow from (%d, %d)", node.Landed[0], node.Landed[1], node.Joins[0], node.Joins[1])
+		if node.Basin >= 0 {
+			fmt.Printf(" over basin %d", node.Basin)
+		}
+		fmt.Println()
+		return
+	}
+	switch {
+	case node.Basin >= 0:
+		b := r.Basins[node.Basin]
+		fmt.Printf("-> basin %d: capacity %d, x %d..%d, y %d..%d\n", b.Id, b.Capacity, b.Min[0], b.Max[0], b.Min[1], b.Max[1])
+	case node.Outcome == "filled":
+		fmt.Printf("-> ledge at (%d, %d)\n", node.Landed[0], node.Landed[1])
+	default:
+		fmt.Printf("-> %s at (%d, %d)\n", node.Outcome, node.Landed[0], node.Landed[1])
+	}
+	for _, s := range node.Spills {
+		r.printNode(s, depth+1)
+	}
+}
+
+func (r *ReservoirReport) Print() {
+	total := 0
+	for _, b := range r.Basins {
+		total += b.Capacity
+	}
+	fmt.Printf("%d basins holding %d tiles of water\n", len(r.Basins), total)
+	for _, s := range r.Springs {
+		fmt.Printf("Spring ")
+		r.printNode(s, 0)
+	}
+}
+
+func (r *ReservoirReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
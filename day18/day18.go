@@ -5,189 +5,111 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"github.com/mcbridejc/adventofcode2018/cellular"
 )
 
-type TileClass int
+type TileClass = cellular.State
 const (
 	Empty TileClass = iota
 	Trees
 	Woodshop
 )
-type Map [][]TileClass
-
-// Set a tile in the map, growing the map as necessary to accomodate
-func (m *Map) Set(x int, y int, tile TileClass) {
-	width := m.Width()
-	height := m.Height()
-
-	// Resize storage as necessary
-	if x >= width {
-		arraysToAdd := x - width + 1
-		for i := 0; i < arraysToAdd; i++ {
-			*m = append(*m, make([]TileClass, height))
-		}
-		width = x + 1
-	}
-	if y >= height {
-		for i := 0; i < width; i++ {
-			(*m)[i] = append((*m)[i], make([]TileClass, y - height + 1)...)
-		}
-	}
-	(*m)[x][y] = tile
-}
 
-func (m Map) Width() int {
-	return len(m)
-}
+// Characters for each TileClass
+const alphabet = ".|#"
+
+// The puzzle's rules, which are the only ones the bit-packed map knows
+const defaultRules = "lumber_rules.txt"
 
-func (m Map) Height() int {
-	height := 0
-	if len(m) > 0 {
-		height = len((m)[0])
+func readLines(filepath string) ([]string, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	
+	lines := make([]string, 0)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
 	}
-	return height 
+	return lines, scanner.Err()
 }
 
-func (m Map) CountAdjacent(x int, y int) map[TileClass]int {
-	ret := make(map[TileClass]int)
-	ret[Empty] = 0
-	ret[Trees] = 0
-	ret[Woodshop] = 0
-	deltas := [][2]int{
-		{-1, -1}, {-1, 0}, {-1, 1}, 
-		{0, -1},           {0, 1}, 
-		{1, -1},  {1, 0},  {1, 1}}
-	for _, delta := range deltas {
-		xp := x + delta[0]
-		yp := y + delta[1]
-		if xp < 0 || xp >= m.Width() || yp < 0 || yp >= m.Height() {
-			continue
-		}
-		ret[m[xp][yp]] += 1
+/** Load the rules for how acres change each minute */
+func LoadRules(filepath string) (cellular.Rule, error) {
+	lines, err := readLines(filepath)
+	if err != nil {
+		return nil, err
 	}
-	return ret
+	return cellular.ParseRules(lines, alphabet)
 }
 
-func Evolve(m Map) Map {
-	var new Map
-
-	// Set the size up front so we allocate just once
-	new.Set(m.Width()-1, m.Height()-1, Empty)
-
-	for x := 0; x < m.Width(); x++ {
-		for y := 0; y < m.Height(); y++ {
-			counts := m.CountAdjacent(x, y)
-			switch(m[x][y]) {
-			case Empty:
-				if counts[Trees] >= 3 {
-					new.Set(x, y, Trees)
-				} else {
-					new.Set(x, y, Empty)
-				}
-			case Trees:
-				if counts[Woodshop] >= 3 {
-					new.Set(x, y, Woodshop)
-				} else {
-					new.Set(x, y, Trees)
-				}
-			case Woodshop:
-				if counts[Woodshop] >= 1 && counts[Trees] >= 1 {
-					new.Set(x, y, Woodshop)
-				} else {
-					new.Set(x, y, Empty)
-				}
-			}
-		}
-	}
-	return new
+func NewLumberAutomaton(m *cellular.Grid, rule cellular.Rule) *cellular.Automaton {
+	return cellular.NewAutomaton(m, cellular.Moore(1), rule, cellular.Fixed)
 }
 
-func MapEq(a Map, b Map) bool {
-	if a.Height() != b.Height() || a.Width() != b.Width() {
-		return false
-	}
-	for y := 0; y < a.Height(); y++ {
-		for x := 0; x < a.Width(); x++ {
-			if a[x][y] != b[x][y] {
-				return false
-			}
-		}
+func LoadMap(filepath string) (*cellular.Grid, error) {
+	lines, err := readLines(filepath)
+	if err != nil {
+		return nil, err
 	}
-	return true
+	return cellular.ParseGrid(lines, alphabet)
 }
 
-func ReadInput(filepath string) Map {
-	f, err := os.Open(filepath)
+func ReadInput(filepath string) *cellular.Grid {
+	m, err := LoadMap(filepath)
 	if err != nil {
 		panic(err)
 	}
-	scanner := bufio.NewScanner(f)
-	
-	m := make(Map, 0)
-	y := 0
-	for scanner.Scan() {
-		for x, rn := range scanner.Text() {
-			if rn == '.' {
-				m.Set(x, y, Empty)
-			} else if rn == '|' {
-				m.Set(x, y, Trees)
-			} else if rn == '#' {
-				m.Set(x, y, Woodshop)
-			}
-		}
-		y++
-	}
 	return m
 }
 
-func PrintMap(m Map) {
-	for y := 0; y < m.Height(); y++ {
-		for x := 0; x < m.Width(); x++ {
-			switch(m[x][y]) {
-			case Empty:
-				fmt.Printf(".")
-			case Trees:
-				fmt.Printf("|")
-			case Woodshop:
-				fmt.Printf("#")
-			}
-		}
-		fmt.Printf("\n")
-	}
+func PrintMap(m *cellular.Grid) {
+	fmt.Print(m.Format(alphabet))
 }
 
-func ResourceValue(m Map) int {
-	trees := 0
-	woodshop := 0
-	for y := 0; y < m.Height(); y++ {
-		for x := 0; x < m.Width(); x++ {
-			switch m[x][y] {
-			case Trees:
-				trees++
-			case Woodshop:
-				woodshop++
-			}
-		}
-	}
-	return trees * woodshop
+func ResourceValue(m *cellular.Grid) int {
+	return m.Count(Trees) * m.Count(Woodshop)
 }
 
 func main() {
 	verbose := flag.Bool("verbose", false, "Print more stuff")
+	inputFile := flag.String("file", "day18_input.txt", "The input file")
+	rulesFile := flag.String("rules", defaultRules, "The rules file")
+	packed := flag.Bool("packed", false, "Use the bit-packed map (which only knows the puzzle's rules)")
+	explore := flag.Bool("explore", false, "Step through the map interactively")
 	flag.Parse()
+	if *packed && *rulesFile != defaultRules {
+		panic("-packed only knows the puzzle's rules, so can't be used with -rules")
+	}
 	fmt.Println("Reading")
 
-	m := ReadInput("day18_input.txt")
+	m := ReadInput(*inputFile)
+	rule, err := LoadRules(*rulesFile)
+	if err != nil {
+		panic(err)
+	}
 
 	fmt.Printf("Read map of size %dx%d\n", m.Width(), m.Height())
 
+	if *explore {
+		NewExplorer(m, rule).Run(os.Stdin)
+		return
+	}
+	if *packed {
+		RunPacked(m)
+		return
+	}
+
 	if *verbose {
 		PrintMap(m)
 	}
 
-
+	lumber := NewLumberAutomaton(m, rule)
 	for generation := 0; generation < 10; generation++ {
-		m = Evolve(m)
+		lumber.Step()
+		m = lumber.Grid()
 		if generation % 1000 == 0 {
 			fmt.Println("Gen ", generation+1)
 		}
@@ -202,46 +124,43 @@ func main() {
 	
 	// Try to find the value after a large number of generations, by assuming it will 
 	// generate a repeated pattern before then
-	m = ReadInput("day18_input.txt")
-	pastMaps := make([]Map, 0)
-	MaxHistory := 100
-
-	repeat := false
-	repeatStart := 0
-	repeatPeriod := 0
-	for generation := 0; generation < 600; generation++ {
-		m = Evolve(m)
-		fmt.Printf("gen %d: %d\n", generation + 1, ResourceValue(m))
-		for i, pm := range pastMaps {
-			if MapEq(pm, m) {
-				//fmt.Printf("Map %d == Map %d\n", generation+1, i)
-				repeat = true
-				repeatPeriod = len(pastMaps) - i
-				repeatStart = generation + 1 - repeatPeriod
-			}
-		}
-		if repeat {
-		 	break
-		}
-		pastMaps = append(pastMaps, m)
-		if len(pastMaps) > MaxHistory {
-			pastMaps = pastMaps[1:]
-		}
+	lumber = NewLumberAutomaton(ReadInput(*inputFile), rule)
+	history := cellular.NewTracker(false)
+	if !history.Run(lumber, 10000) {
+		panic("Map never repeated")
 	}
+	repeatStart, repeatPeriod, _ := history.Cycle()
 
-	repeatingScores := make([]int, 0)
-	for i := len(pastMaps) - repeatPeriod; i < len(pastMaps); i++ {
-		repeatingScores = append(repeatingScores, ResourceValue(pastMaps[i]))
-	}
-	for i, v := range repeatingScores {
-		fmt.Println(i, ": ", v)
+	for i := 0; i < repeatPeriod; i++ {
+		pm, _ := history.StateAt(repeatStart + i)
+		fmt.Println(i, ": ", ResourceValue(pm))
 	}
 
 	largeGenerations := 1000000000
-	repeatIdx := (largeGenerations - repeatStart) % repeatPeriod
+	fmt.Println("Repeat start: ", repeatStart)
+	fmt.Println("Repeat period: ", repeatPeriod)
+	final, _ := history.StateAt(largeGenerations)
+	fmt.Printf("Predicted resource value after %d iterations: %d\n", largeGenerations, ResourceValue(final))
+}
 
+/** Both parts of the puzzle, on the bit-packed map */
+func RunPacked(m *cellular.Grid) {
+	pm := PackMap(m)
+	for generation := 0; generation < 10; generation++ {
+		pm = pm.Evolve()
+	}
+	fmt.Printf("Final resource value after 10 iterations: %d\n", pm.ResourceValue())
+
+	pm = PackMap(m)
+	history := cellular.NewTracker(false)
+	for generation := 0; !history.Add(generation, pm.Unpack()); generation++ {
+		pm = pm.Evolve()
+	}
+	repeatStart, repeatPeriod, _ := history.Cycle()
+
+	largeGenerations := 1000000000
 	fmt.Println("Repeat start: ", repeatStart)
 	fmt.Println("Repeat period: ", repeatPeriod)
-	fmt.Println("RepeatingScore[0]: ", repeatingScores[0], len(repeatingScores))
-	fmt.Printf("Predicted resource value after %d iterations: %d\n", largeGenerations, repeatingScores[repeatIdx])
-}
\ No newline at end of file
+	final, _ := history.StateAt(largeGenerations)
+	fmt.Printf("Predicted resource value after %d iterations: %d\n", largeGenerations, ResourceValue(final))
+}
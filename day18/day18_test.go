@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"github.com/mcbridejc/adventofcode2018/cellular"
+)
+
+func loadPuzzle(t testing.TB) (*cellular.Grid, cellular.Rule) {
+	m, err := LoadMap("day18_input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := LoadRules("lumber_rules.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, rule
+}
+
+func TestPackedMatchesEngine(t *testing.T) {
+	m, rule := loadPuzzle(t)
+	lumber := NewLumberAutomaton(m, rule)
+	pm := PackMap(m)
+	for gen := 1; gen <= 1000; gen++ {
+		lumber.Step()
+		pm = pm.Evolve()
+		if want, got := ResourceValue(lumber.Grid()), pm.ResourceValue(); got != want {
+			t.Fatalf("Generation %d: packed resource value %d, engine %d", gen, got, want)
+		}
+		if !pm.Unpack().Equal(lumber.Grid()) {
+			t.Fatalf("Generation %d: packed map differs from engine", gen)
+		}
+	}
+}
+
+func BenchmarkEvolveEngine(b *testing.B) {
+	m, rule := loadPuzzle(b)
+	a := NewLumberAutomaton(m, rule)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.Step()
+	}
+}
+
+func BenchmarkEvolvePacked(b *testing.B) {
+	m, _ := loadPuzzle(b)
+	pm := PackMap(m)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pm = pm.Evolve()
+	}
+}
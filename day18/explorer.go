@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"github.com/mcbridejc/adventofcode2018/cellular"
+)
+
+/* Interactive explorer
+ *
+ * Reads commands from stdin and steps a map forward under the current rules,
+ * keeping every generation it has seen so they can be looked back at, graphed
+ * or written out as an animation.
+ */
+
+// Palette indices line up with TileClass
+var lumberPalette = color.Palette{
+	Empty: color.RGBA{0xd8, 0xc8, 0x98, 0xff},
+	Trees: color.RGBA{0x2e, 0x8b, 0x3a, 0xff},
+	Woodshop: color.RGBA{0x6b, 0x3e, 0x1e, 0xff},
+}
+
+// Sparkline characters, from lowest to highest
+const sparkChars = "_.-~=+*#"
+
+const explorerHelp = `Commands:
+  step [n]                  Advance n generations (default 1)
+  show [gen]                Print the map at a generation (default the latest)
+  values [from] [to]        Sparkline of the resource value over generations
+  gif <file> [from] [to] [scale]
+                            Write generations out as an animated GIF
+  load <file>               Load a new map and start again from generation 0
+  rules <file>              Load new rules and start again from generation 0
+  help                      Print this
+  quit                      Leave
+`
+
+type Explorer struct {
+	start *cellular.Grid
+	rule cellular.Rule
+	lumber *cellular.Automaton
+	history []*cellular.Grid // By generation
+	values []int // Resource value by generation
+}
+
+func NewExplorer(m *cellular.Grid, rule cellular.Rule) *Explorer {
+	e := &Explorer{start: m, rule: rule}
+	e.Reset()
+	return e
+}
+
+/** Go back to generation 0 of the starting map */
+func (e *Explorer) Reset() {
+	e.lumber = NewLumberAutomaton(e.start.Copy(), e.rule)
+	e.history = []*cellular.Grid{e.lumber.Grid()}
+	e.values = []int{ResourceValue(e.lumber.Grid())}
+}
+
+func (e *Explorer) Generation() int {
+	return e.lumber.Generation()
+}
+
+func (e *Explorer) Step(n int) {
+	for i := 0; i < n; i++ {
+		e.lumber.Step()
+		e.history = append(e.history, e.lumber.Grid())
+		e.values = append(e.values, ResourceValue(e.lumber.Grid()))
+	}
+}
+
+/** Values drawn as one character each, scaled so the smallest is the lowest
+ * character and the largest the highest
+ */
+func Sparkline(values []int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	line := make([]byte, len(values))
+	for i, v := range values {
+		level := 0
+		if max > min {
+			level = (v - min) * (len(sparkChars) - 1) / (max - min)
+		}
+		line[i] = sparkChars[level]
+	}
+	return string(line)
+}
+
+func (e *Explorer) Image(generation, scale int) *image.Paletted {
+	m := e.history[generation]
+	img := image.NewPaletted(image.Rect(0, 0, m.Width()*scale, m.Height()*scale), lumberPalette)
+	for y := 0; y < m.Height(); y++ {
+		for x := 0; x < m.Width(); x++ {
+			c := uint8(m.Get(x, y))
+			for j := 0; j < scale; j++ {
+				for i := 0; i < scale; i++ {
+					img.SetColorIndex(x*scale+i, y*scale+j, c)
+				}
+			}
+		}
+	}
+	return img
+}
+
+/** Write generations from to to (inclusive) as an animated GIF */
+func (e *Explorer) WriteGIF(path string, from, to, scale int) error {
+	anim := &gif.GIF{}
+	for gen := from; gen <= to; gen++ {
+		anim.Image = append(anim.Image, e.Image(gen, scale))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gif.EncodeAll(f, anim)
+}
+
+/** Parse optional integer arguments, filling in defaults for any that are
+ * missing
+ */
+func intArgs(args []string, defaults ...int) ([]int, error) {
+	if len(args) > len(defaults) {
+		return nil, fmt.Errorf("Too many arguments")
+	}
+	values := append([]int{}, defaults...)
+	for i, a := range args {
+		v, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, fmt.Errorf("Bad number %q", a)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+/** Check a range of generations, stepping forward to reach the end of it if
+ * needed
+ */
+func (e *Explorer) generationRange(from, to int) error {
+	if from < 0 || to < from {
+		return fmt.Errorf("Bad range %d to %d", from, to)
+	}
+	if to > e.Generation() {
+		e.Step(to - e.Generation())
+	}
+	return nil
+}
+
+/** Carry out a single command. Returns false when it's time to leave. */
+func (e *Explorer) Command(line string) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true, nil
+	}
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "step", "s":
+		n, err := intArgs(args, 1)
+		if err != nil {
+			return true, err
+		}
+		e.Step(n[0])
+		fmt.Printf("Generation %d: resource value %d\n", e.Generation(), e.values[e.Generation()])
+	case "show", "p":
+		gen, err := intArgs(args, e.Generation())
+		if err != nil {
+			return true, err
+		}
+		if err := e.generationRange(gen[0], gen[0]); err != nil {
+			return true, err
+		}
+		fmt.Printf("Generation %d: resource value %d\n", gen[0], e.values[gen[0]])
+		PrintMap(e.history[gen[0]])
+	case "values", "v":
+		r, err := intArgs(args, 0, e.Generation())
+		if err != nil {
+			return true, err
+		}
+		if err := e.generationRange(r[0], r[1]); err != nil {
+			return true, err
+		}
+		values := e.values[r[0]:r[1]+1]
+		min, max := values[0], values[0]
+		for _, v := range values {
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		fmt.Printf("Generations %d to %d, resource value from %d to %d\n", r[0], r[1], min, max)
+		// Wrap long runs so each line stays a readable width
+		line := Sparkline(values)
+		for i := 0; i < len(line); i += 72 {
+			end := i + 72
+			if end > len(line) {
+				end = len(line)
+			}
+			fmt.Printf("%6d %s\n", r[0] + i, line[i:end])
+		}
+	case "gif":
+		if len(args) < 1 {
+			return true, fmt.Errorf("gif needs a file name")
+		}
+		r, err := intArgs(args[1:], 0, e.Generation(), 4)
+		if err != nil {
+			return true, err
+		}
+		if err := e.generationRange(r[0], r[1]); err != nil {
+			return true, err
+		}
+		if r[2] < 1 {
+			return true, fmt.Errorf("Bad scale %d", r[2])
+		}
+		if err := e.WriteGIF(args[0], r[0], r[1], r[2]); err != nil {
+			return true, err
+		}
+		fmt.Printf("Wrote generations %d to %d to %s\n", r[0], r[1], args[0])
+	case "load":
+		if len(args) != 1 {
+			return true, fmt.Errorf("load needs a file name")
+		}
+		m, err := LoadMap(args[0])
+		if err != nil {
+			return true, err
+		}
+		e.start = m
+		e.Reset()
+		fmt.Printf("Loaded map of size %dx%d\n", m.Width(), m.Height())
+	case "rules":
+		if len(args) != 1 {
+			return true, fmt.Errorf("rules needs a file name")
+		}
+		rule, err := LoadRules(args[0])
+		if err != nil {
+			return true, err
+		}
+		e.rule = rule
+		e.Reset()
+		fmt.Println("Loaded rules, back to generation 0")
+	case "help", "h", "?":
+		fmt.Print(explorerHelp)
+	case "quit", "q", "exit":
+		return false, nil
+	default:
+		return true, fmt.Errorf("Unknown command %q, try help", cmd)
+	}
+	return true, nil
+}
+
+/** Read commands until told to quit or the input runs out */
+func (e *Explorer) Run(in io.Reader) {
+	fmt.Print(explorerHelp)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Printf("[gen %d] > ", e.Generation())
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		more, err := e.Command(scanner.Text())
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+		if !more {
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math/bits"
+	"github.com/mcbridejc/adventofcode2018/cellular"
+)
+
+/* Bit-packed lumber map
+ *
+ * Each row is stored as two bitplanes, one for trees and one for lumberyards,
+ * 64 acres to a word. Rather than counting neighbours one acre at a time, the
+ * eight neighbour masks of a word are added together with bitwise adders,
+ * giving a 4 bit count for all 64 acres at once.
+ */
+
+type PackedMap struct {
+	width int
+	height int
+	words int // Words per row
+	trees []uint64
+	lumber []uint64
+	mask []uint64 // Bits which are inside the map, for one row
+}
+
+func NewPackedMap(width, height int) *PackedMap {
+	words := (width + 63) / 64
+	pm := &PackedMap{width, height, words, make([]uint64, words*height), make([]uint64, words*height), make([]uint64, words)}
+	for x := 0; x < width; x++ {
+		pm.mask[x/64] |= 1 << uint(x%64)
+	}
+	return pm
+}
+
+func PackMap(m *cellular.Grid) *PackedMap {
+	pm := NewPackedMap(m.Width(), m.Height())
+	for y := 0; y < m.Height(); y++ {
+		for x := 0; x < m.Width(); x++ {
+			pm.Set(x, y, m.Get(x, y))
+		}
+	}
+	return pm
+}
+
+func (pm *PackedMap) Set(x, y int, tile TileClass) {
+	i := y*pm.words + x/64
+	bit := uint64(1) << uint(x%64)
+	pm.trees[i] &^= bit
+	pm.lumber[i] &^= bit
+	switch tile {
+	case Trees:
+		pm.trees[i] |= bit
+	case Woodshop:
+		pm.lumber[i] |= bit
+	}
+}
+
+func (pm *PackedMap) Get(x, y int) TileClass {
+	i := y*pm.words + x/64
+	bit := uint64(1) << uint(x%64)
+	if pm.trees[i] & bit != 0 {
+		return Trees
+	} else if pm.lumber[i] & bit != 0 {
+		return Woodshop
+	}
+	return Empty
+}
+
+func (pm *PackedMap) Unpack() *cellular.Grid {
+	m := cellular.NewGrid(pm.width, pm.height)
+	for y := 0; y < pm.height; y++ {
+		for x := 0; x < pm.width; x++ {
+			m.Set(x, y, pm.Get(x, y))
+		}
+	}
+	return m
+}
+
+/** Add a mask of ones into a 4 bit counter held as one word per bit */
+func addBits(count *[4]uint64, m uint64) {
+	for k := 0; k < 4 && m != 0; k++ {
+		carry := count[k] & m
+		count[k] ^= m
+		m = carry
+	}
+}
+
+/** Count, for each acre in word w of row y, how many of its eight neighbours
+ * are set in plane
+ */
+func (pm *PackedMap) countNeighbours(plane []uint64, y, w int) [4]uint64 {
+	var count [4]uint64
+	for dy := -1; dy <= 1; dy++ {
+		ny := y + dy
+		if ny < 0 || ny >= pm.height {
+			continue
+		}
+		row := plane[ny*pm.words : (ny+1)*pm.words]
+		cur := row[w]
+		// Neighbours to the left and right come from shifting the row,
+		// pulling in a bit from the next word over where there is one
+		left := cur << 1
+		if w > 0 {
+			left |= row[w-1] >> 63
+		}
+		right := cur >> 1
+		if w + 1 < pm.words {
+			right |= row[w+1] << 63
+		}
+		addBits(&count, left)
+		addBits(&count, right)
+		if dy != 0 {
+			addBits(&count, cur)
+		}
+	}
+	return count
+}
+
+func atLeastOne(c [4]uint64) uint64 {
+	return c[0] | c[1] | c[2] | c[3]
+}
+
+func atLeastThree(c [4]uint64) uint64 {
+	return c[3] | c[2] | (c[1] & c[0])
+}
+
+/** Advance the map by one minute, using the puzzle's rules */
+func (pm *PackedMap) Evolve() *PackedMap {
+	next := NewPackedMap(pm.width, pm.height)
+	for y := 0; y < pm.height; y++ {
+		for w := 0; w < pm.words; w++ {
+			i := y*pm.words + w
+			trees := pm.trees[i]
+			lumber := pm.lumber[i]
+			open := ^(trees | lumber) & pm.mask[w]
+			treeCount := pm.countNeighbours(pm.trees, y, w)
+			lumberCount := pm.countNeighbours(pm.lumber, y, w)
+
+			next.trees[i] = (open & atLeastThree(treeCount)) | (trees &^ atLeastThree(lumberCount))
+			next.lumber[i] = (trees & atLeastThree(lumberCount)) | (lumber & atLeastOne(lumberCount) & atLeastOne(treeCount))
+		}
+	}
+	return next
+}
+
+func (pm *PackedMap) ResourceValue() int {
+	trees := 0
+	lumber := 0
+	for i := range pm.trees {
+		trees += bits.OnesCount64(pm.trees[i])
+		lumber += bits.OnesCount64(pm.lumber[i])
+	}
+	return trees * lumber
+}
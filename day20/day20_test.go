@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestFormatAtlasExample(t *testing.T) {
+	directions, err := ioutil.ReadFile("day20_example1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, err := ParseRoute(string(directions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := Coordinate{0, 0}
+	atlas := BuildAtlas(route, start)
+	dm := FindDistances(atlas, start)
+	if _, d := dm.Farthest(); d != 23 {
+		t.Fatalf("Farthest room is %d doors away, want 23", d)
+	}
+
+	// The puzzle's drawing, with the 25 rooms at least 10 doors away as :
+	// and the one 23 doors away as F
+	want := `#############
+#:|:|:|:|:|:#
+#-#####-###-#
+#:#:|F#:#:#:#
+#-#-###-#-#-#
+#:#:#.|.#:|:#
+#-#-#-#####-#
+#:#:#.#X|.#:#
+#-#-#-###-#-#
+#:|:#.|.#.#:#
+###-#-###-#-#
+#:|:#.|.|.#:#
+#############
+`
+	if got := FormatAtlas(atlas, dm, 10); got != want {
+		t.Errorf("Atlas drawn as\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* Shortest paths through the facility
+ *
+ * A breadth first search from the start reaches each room for the first
+ * time by the fewest doors, so every room is only looked at once. The room
+ * each one was reached from is remembered, which is enough to walk any
+ * shortest path back to the start.
+ */
+
+type DistanceMap struct {
+	start Coordinate
+	distance map[Coordinate]int
+	from map[Coordinate]Coordinate // The room each room was first reached from
+	farthest Coordinate
+}
+
+type door struct {
+	dir rune
+	next func(r *Room) *Room
+}
+
+var doors = []door{
+	{'N', func(r *Room) *Room { return r.N }},
+	{'E', func(r *Room) *Room { return r.E }},
+	{'S', func(r *Room) *Room { return r.S }},
+	{'W', func(r *Room) *Room { return r.W }},
+}
+
+/** Find the fewest doors to every room from start */
+func FindDistances(atlas map[Coordinate]*Room, start Coordinate) *DistanceMap {
+	dm := &DistanceMap{
+		start: start,
+		distance: map[Coordinate]int{start: 0},
+		from: make(map[Coordinate]Coordinate),
+		farthest: start,
+	}
+	queue := []Coordinate{start}
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		room := atlas[pos]
+		dist := dm.distance[pos]
+		if dist > dm.distance[dm.farthest] {
+			dm.farthest = pos
+		}
+		for _, d := range doors {
+			if d.next(room) == nil {
+				continue
+			}
+			off := stepOffsets[d.dir]
+			next := Coordinate{pos.x + off.x, pos.y + off.y}
+			if _, seen := dm.distance[next]; seen {
+				continue
+			}
+			dm.distance[next] = dist + 1
+			dm.from[next] = pos
+			queue = append(queue, next)
+		}
+	}
+	return dm
+}
+
+/** Fewest doors from the start to the room at c. ok is false if there's no
+ * way there.
+ */
+func (dm *DistanceMap) Distance(c Coordinate) (d int, ok bool) {
+	d, ok = dm.distance[c]
+	return d, ok
+}
+
+/** The room the most doors away, and how many doors that is */
+func (dm *DistanceMap) Farthest() (Coordinate, int) {
+	return dm.farthest, dm.distance[dm.farthest]
+}
+
+/** Rooms along a shortest path from the start to c, including both ends */
+func (dm *DistanceMap) Path(c Coordinate) ([]Coordinate, bool) {
+	d, ok := dm.distance[c]
+	if !ok {
+		return nil, false
+	}
+	path := make([]Coordinate, d + 1)
+	for i := d; i > 0; i-- {
+		path[i] = c
+		c = dm.from[c]
+	}
+	path[0] = c
+	return path, true
+}
+
+/** A shortest path to c as the directions to follow, like NNEES */
+func (dm *DistanceMap) Directions(c Coordinate) (string, bool) {
+	path, ok := dm.Path(c)
+	if !ok {
+		return "", false
+	}
+	var sb strings.Builder
+	for i := 1; i < len(path); i++ {
+		step := Coordinate{path[i].x - path[i-1].x, path[i].y - path[i-1].y}
+		for dir, off := range stepOffsets {
+			if off == step {
+				sb.WriteRune(dir)
+			}
+		}
+	}
+	return sb.String(), true
+}
+
+/** Number of rooms at least n doors away */
+func (dm *DistanceMap) CountAtLeast(n int) int {
+	count := 0
+	for _, d := range dm.distance {
+		if d >= n {
+			count++
+		}
+	}
+	return count
+}
+
+/** Number of rooms at each distance, in buckets of bucketSize doors */
+func (dm *DistanceMap) Histogram(bucketSize int) []int {
+	_, max := dm.Farthest()
+	hist := make([]int, max/bucketSize + 1)
+	for _, d := range dm.distance {
+		hist[d/bucketSize]++
+	}
+	return hist
+}
+
+/** Print the histogram with a bar for each bucket */
+func (dm *DistanceMap) PrintHistogram(bucketSize, width int) {
+	hist := dm.Histogram(bucketSize)
+	most := 0
+	for _, n := range hist {
+		if n > most {
+			most = n
+		}
+	}
+	for i, n := range hist {
+		fmt.Printf("%5d-%-5d %6d %s\n", i*bucketSize, (i+1)*bucketSize - 1, n, strings.Repeat("*", n*width/most))
+	}
+}
+
+/** Parse a room coordinate written as x,y */
+func ParseCoordinate(s string) (Coordinate, error) {
+	var c Coordinate
+	if _, err := fmt.Sscanf(s, "%d,%d", &c.x, &c.y); err != nil {
+		return c, fmt.Errorf("Bad coordinate %q, should be x,y", s)
+	}
+	return c, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* Route regex parser
+ *
+ * The route is parsed once into a tree: runs of plain directions, sequences
+ * of parts one after another, and alternations between options (any of
+ * which may be empty). Walking the tree carries the whole set of rooms you
+ * could be standing in, so options which end up in different places all
+ * carry on with the rest of the route.
+ */
+
+type NodeKind int
+const (
+	Steps NodeKind = iota // A run of directions, like NEWS
+	Sequence // Children one after another
+	Alternation // Any one of the children, like (N|S|)
+)
+
+type Node struct {
+	kind NodeKind
+	steps string // Only for Steps
+	children []*Node
+}
+
+type ParseError struct {
+	pos int
+	msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Route error at character %d: %s", e.pos, e.msg)
+}
+
+type routeParser struct {
+	route string
+	pos int
+}
+
+func (p *routeParser) fail(format string, args ...interface{}) *ParseError {
+	return &ParseError{p.pos, fmt.Sprintf(format, args...)}
+}
+
+/** Parse a sequence, stopping at the end of the route or at a | or ) which
+ * belongs to whoever called us
+ */
+func (p *routeParser) sequence() (*Node, error) {
+	seq := &Node{kind: Sequence}
+	for p.pos < len(p.route) {
+		c := p.route[p.pos]
+		switch {
+		case strings.IndexByte("NESW", c) >= 0:
+			start := p.pos
+			for p.pos < len(p.route) && strings.IndexByte("NESW", p.route[p.pos]) >= 0 {
+				p.pos++
+			}
+			seq.children = append(seq.children, &Node{kind: Steps, steps: p.route[start:p.pos]})
+		case c == '(':
+			p.pos++
+			alt, err := p.alternation()
+			if err != nil {
+				return nil, err
+			}
+			seq.children = append(seq.children, alt)
+		case c == '|' || c == ')':
+			return seq, nil
+		default:
+			return nil, p.fail("unexpected %q", c)
+		}
+	}
+	return seq, nil
+}
+
+/** Parse the options of a group, just after its ( and up to its ) */
+func (p *routeParser) alternation() (*Node, error) {
+	alt := &Node{kind: Alternation}
+	for {
+		option, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		alt.children = append(alt.children, option)
+		if p.pos >= len(p.route) {
+			return nil, p.fail("unclosed (")
+		}
+		p.pos++
+		if p.route[p.pos-1] == ')' {
+			return alt, nil
+		}
+	}
+}
+
+/** Parse a route such as ^ENWWW(NEEE|SSE(EE|N))$. The ^ and $ are optional. */
+func ParseRoute(route string) (*Node, error) {
+	route = strings.TrimSpace(route)
+	p := &routeParser{route: route}
+	if strings.HasPrefix(route, "^") {
+		p.pos++
+	}
+	if strings.HasSuffix(route, "$") {
+		p.route = route[:len(route)-1]
+	}
+	tree, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.route) {
+		return nil, p.fail("unmatched %q", p.route[p.pos])
+	}
+	return tree, nil
+}
+
+/** The node written back out as a regex, without the ^ and $ */
+func (n *Node) String() string {
+	switch n.kind {
+	case Steps:
+		return n.steps
+	case Sequence:
+		var sb strings.Builder
+		for _, c := range n.children {
+			sb.WriteString(c.String())
+		}
+		return sb.String()
+	}
+	options := make([]string, len(n.children))
+	for i, c := range n.children {
+		options[i] = c.String()
+	}
+	return "(" + strings.Join(options, "|") + ")"
+}
+
+type PositionSet map[Coordinate]bool
+
+var stepOffsets = map[rune]Coordinate{
+	'N': {0, -1},
+	'E': {1, 0},
+	'S': {0, 1},
+	'W': {-1, 0},
+}
+
+/** Open the door from the room at pos in direction dir, creating the room on
+ * the other side if needed, and return where that leaves us
+ */
+func Move(atlas map[Coordinate]*Room, pos Coordinate, dir rune) Coordinate {
+	off := stepOffsets[dir]
+	next := Coordinate{pos.x + off.x, pos.y + off.y}
+	from := FindOrCreateRoom(atlas, pos)
+	to := FindOrCreateRoom(atlas, next)
+	switch dir {
+	case 'N':
+		from.N, to.S = to, from
+	case 'E':
+		from.E, to.W = to, from
+	case 'S':
+		from.S, to.N = to, from
+	case 'W':
+		from.W, to.E = to, from
+	}
+	return next
+}
+
+/** Follow the node from every room in starts, adding the doors it passes
+ * through to the atlas. Returns every room it could finish in.
+ */
+func (n *Node) Walk(atlas map[Coordinate]*Room, starts PositionSet) PositionSet {
+	switch n.kind {
+	case Steps:
+		ends := make(PositionSet, len(starts))
+		for pos := range starts {
+			for _, dir := range n.steps {
+				pos = Move(atlas, pos, dir)
+			}
+			ends[pos] = true
+		}
+		return ends
+	case Sequence:
+		for _, c := range n.children {
+			starts = c.Walk(atlas, starts)
+		}
+		return starts
+	}
+	ends := make(PositionSet)
+	for _, c := range n.children {
+		for pos := range c.Walk(atlas, starts) {
+			ends[pos] = true
+		}
+	}
+	return ends
+}
+
+/** Build the atlas of every room and door the route passes through */
+func BuildAtlas(route *Node, start Coordinate) map[Coordinate]*Room {
+	atlas := make(map[Coordinate]*Room)
+	FindOrCreateRoom(atlas, start)
+	route.Walk(atlas, PositionSet{start: true})
+	return atlas
+}
+
+/** Every complete route the regex matches, up to limit of them. truncated is
+ * true if there were more.
+ */
+func (n *Node) Routes(limit int) (routes []string, truncated bool) {
+	switch n.kind {
+	case Steps:
+		return []string{n.steps}, false
+	case Sequence:
+		routes = []string{""}
+		for _, c := range n.children {
+			childRoutes, childTruncated := c.Routes(limit)
+			truncated = truncated || childTruncated
+			next := make([]string, 0, len(routes))
+			for _, prefix := range routes {
+				for _, suffix := range childRoutes {
+					if len(next) == limit {
+						truncated = true
+						break
+					}
+					next = append(next, prefix + suffix)
+				}
+			}
+			routes = next
+		}
+		return routes, truncated
+	}
+	for _, c := range n.children {
+		childRoutes, childTruncated := c.Routes(limit - len(routes))
+		truncated = truncated || childTruncated
+		routes = append(routes, childRoutes...)
+		if len(routes) == limit {
+			// Only truncated if there's anything left we didn't get to
+			truncated = truncated || c != n.children[len(n.children)-1]
+			break
+		}
+	}
+	return routes, truncated
+}
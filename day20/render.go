@@ -0,0 +1,196 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+)
+
+/* Atlas rendering
+ *
+ * Both the text and the image use the puzzle's layout, where each room and
+ * each wall or door between rooms gets a cell of its own. Room (x, y) is at
+ * cell (2x+1, 2y+1) counting from the top left room.
+ */
+
+/** The smallest and largest room coordinates in the atlas */
+func AtlasBounds(atlas map[Coordinate]*Room) (min, max Coordinate) {
+	first := true
+	for c := range atlas {
+		if first {
+			min, max, first = c, c, false
+			continue
+		}
+		if c.x < min.x { min.x = c.x }
+		if c.y < min.y { min.y = c.y }
+		if c.x > max.x { max.x = c.x }
+		if c.y > max.y { max.y = c.y }
+	}
+	return min, max
+}
+
+/** The atlas as a grid of cells, in the puzzle's #|-.X form */
+func atlasCells(atlas map[Coordinate]*Room, start Coordinate) [][]byte {
+	min, max := AtlasBounds(atlas)
+	width := 2*(max.x - min.x + 1) + 1
+	height := 2*(max.y - min.y + 1) + 1
+	cells := make([][]byte, height)
+	for y := range cells {
+		cells[y] = []byte(strings.Repeat("#", width))
+	}
+	for c, room := range atlas {
+		cx := 2*(c.x - min.x) + 1
+		cy := 2*(c.y - min.y) + 1
+		cells[cy][cx] = '.'
+		if c == start {
+			cells[cy][cx] = 'X'
+		}
+		if room.N != nil { cells[cy-1][cx] = '-' }
+		if room.S != nil { cells[cy+1][cx] = '-' }
+		if room.E != nil { cells[cy][cx+1] = '|' }
+		if room.W != nil { cells[cy][cx-1] = '|' }
+	}
+	return cells
+}
+
+/** Render the atlas as text, the way the puzzle draws it, with the
+ * farthest room drawn as F and the rooms at least threshold doors away, the
+ * ones part 2 counts, as : so the boundary is where the dots stop
+ */
+func FormatAtlas(atlas map[Coordinate]*Room, dm *DistanceMap, threshold int) string {
+	min, _ := AtlasBounds(atlas)
+	cells := atlasCells(atlas, dm.start)
+	for c := range atlas {
+		if d, _ := dm.Distance(c); d >= threshold && c != dm.start {
+			cells[2*(c.y - min.y) + 1][2*(c.x - min.x) + 1] = ':'
+		}
+	}
+	if farthest, _ := dm.Farthest(); farthest != dm.start {
+		cells[2*(farthest.y - min.y) + 1][2*(farthest.x - min.x) + 1] = 'F'
+	}
+
+	var sb strings.Builder
+	for _, row := range cells {
+		sb.Write(row)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+var (
+	wallColor = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	startColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	farthestColor = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	boundaryColor = color.RGBA{0xff, 0x00, 0xff, 0xff}
+)
+
+/** Shade for a distance, going from blue near the start through green to
+ * yellow at the farthest room
+ */
+func heatColor(distance, maxDistance int) color.RGBA {
+	t := 0.0
+	if maxDistance > 0 {
+		t = float64(distance) / float64(maxDistance)
+	}
+	if t < 0.5 {
+		t *= 2
+		return color.RGBA{0x20, uint8(0x40 + t*0xa0), uint8(0xe0 - t*0xa0), 0xff}
+	}
+	t = (t - 0.5) * 2
+	return color.RGBA{uint8(0x20 + t*0xdf), 0xe0, uint8(0x40 - t*0x40), 0xff}
+}
+
+/** Draw the atlas with each room coloured by its distance from the start.
+ * The start and the farthest room are circled, and a line is drawn between
+ * rooms less than threshold doors away and the ones part 2 counts.
+ */
+func AtlasImage(atlas map[Coordinate]*Room, dm *DistanceMap, threshold, scale int) *image.RGBA {
+	min, _ := AtlasBounds(atlas)
+	start := dm.start
+	cells := atlasCells(atlas, start)
+	farthest, maxDistance := dm.Farthest()
+	distance := func(c Coordinate) int {
+		d, _ := dm.Distance(c)
+		return d
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, len(cells[0])*scale, len(cells)*scale))
+	fill := func(cx, cy int, col color.RGBA) {
+		if cx < 0 || cy < 0 || cy >= len(cells) || cx >= len(cells[0]) {
+			return
+		}
+		for j := 0; j < scale; j++ {
+			for i := 0; i < scale; i++ {
+				img.SetRGBA(cx*scale+i, cy*scale+j, col)
+			}
+		}
+	}
+	cellOf := func(c Coordinate) (int, int) {
+		return 2*(c.x - min.x) + 1, 2*(c.y - min.y) + 1
+	}
+	for cy, row := range cells {
+		for cx := range row {
+			fill(cx, cy, wallColor)
+		}
+	}
+	for c, room := range atlas {
+		cx, cy := cellOf(c)
+		south := Coordinate{c.x, c.y + 1}
+		east := Coordinate{c.x + 1, c.y}
+		fill(cx, cy, heatColor(distance(c), maxDistance))
+		// Doors take the colour of the farther room
+		if room.S != nil { fill(cx, cy+1, heatColor(farther(distance(c), distance(south)), maxDistance)) }
+		if room.E != nil { fill(cx+1, cy, heatColor(farther(distance(c), distance(east)), maxDistance)) }
+	}
+
+	// The boundary goes over whatever is between two neighbouring rooms on
+	// either side of it, wall or door
+	inside := func(c Coordinate) bool { return distance(c) < threshold }
+	for c := range atlas {
+		cx, cy := cellOf(c)
+		if south := (Coordinate{c.x, c.y + 1}); atlas[south] != nil && inside(south) != inside(c) {
+			for dx := -1; dx <= 1; dx++ {
+				fill(cx + dx, cy + 1, boundaryColor)
+			}
+		}
+		if east := (Coordinate{c.x + 1, c.y}); atlas[east] != nil && inside(east) != inside(c) {
+			for dy := -1; dy <= 1; dy++ {
+				fill(cx + 1, cy + dy, boundaryColor)
+			}
+		}
+	}
+
+	// A ring around the start and the farthest room, so they can be found
+	mark := func(c Coordinate, col color.RGBA) {
+		cx, cy := cellOf(c)
+		fill(cx, cy, col)
+		for d := -2; d <= 2; d++ {
+			fill(cx + d, cy - 2, col)
+			fill(cx + d, cy + 2, col)
+			fill(cx - 2, cy + d, col)
+			fill(cx + 2, cy + d, col)
+		}
+	}
+	mark(start, startColor)
+	mark(farthest, farthestColor)
+	return img
+}
+
+/** Distance to whichever of two rooms is farther from the start */
+func farther(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
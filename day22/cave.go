@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Region int
+
+type Coordinate struct {
+	x int
+	y int
+}
+
+/* The cave is worked out as it's explored. A region's erosion level depends
+ * on the regions above and to the left of it, so it's kept as a rectangle
+ * from the mouth which gets bigger whenever something outside of it is asked
+ * for.
+ */
+type Cave struct {
+	depth int64
+	target Coordinate
+	regionTypes int
+	erosion [][]int64 // By y then x
+	width int
+}
+
+func NewCave(depth int, target Coordinate, regionTypes int) *Cave {
+	return &Cave{depth: int64(depth), target: target, regionTypes: regionTypes}
+}
+
+func (c *Cave) Depth() int { return int(c.depth) }
+func (c *Cave) Target() Coordinate { return c.target }
+
+/** Make sure erosion levels have been worked out up to and including (x, y).
+ * The rectangle grows by at least double each time, so exploring outwards a
+ * step at a time doesn't keep rebuilding it.
+ */
+func (c *Cave) grow(x, y int) {
+	height := len(c.erosion)
+	if x < c.width && y < height {
+		return
+	}
+	newWidth, newHeight := c.width, height
+	if x >= c.width {
+		newWidth = x + 1
+		if newWidth < 2*c.width {
+			newWidth = 2*c.width
+		}
+	}
+	if y >= height {
+		newHeight = y + 1
+		if newHeight < 2*height {
+			newHeight = 2*height
+		}
+	}
+	for len(c.erosion) < newHeight {
+		c.erosion = append(c.erosion, make([]int64, 0, newWidth))
+	}
+	// Fill in row by row, so the regions above and to the left are always
+	// already there
+	for ry := 0; ry < newHeight; ry++ {
+		for rx := len(c.erosion[ry]); rx < newWidth; rx++ {
+			c.erosion[ry] = append(c.erosion[ry], (c.geologicIndex(rx, ry) + c.depth) % 20183)
+		}
+	}
+	c.width = newWidth
+}
+
+func (c *Cave) Erosion(x, y int) int64 {
+	c.grow(x, y)
+	return c.erosion[y][x]
+}
+
+/** The geologic index of the region at (x, y) */
+func (c *Cave) GeologicIndex(x, y int) int64 {
+	c.grow(x, y)
+	return c.geologicIndex(x, y)
+}
+
+/** The geologic index, for a region whose neighbours above and to the left
+ * have already been worked out
+ */
+func (c *Cave) geologicIndex(x, y int) int64 {
+	switch {
+	case x == 0 && y == 0, x == c.target.x && y == c.target.y:
+		return 0
+	case y == 0:
+		return int64(x) * 16807
+	case x == 0:
+		return int64(y) * 48271
+	}
+	return c.erosion[y][x-1] * c.erosion[y-1][x]
+}
+
+func (c *Cave) Region(x, y int) Region {
+	return Region(c.Erosion(x, y) % int64(c.regionTypes))
+}
+
+/** Sum of the region types in the rectangle from the mouth to the target */
+func (c *Cave) Risk() int {
+	risk := 0
+	for y := 0; y <= c.target.y; y++ {
+		for x := 0; x <= c.target.x; x++ {
+			risk += int(c.Region(x, y))
+		}
+	}
+	return risk
+}
+
+/** The regions from the mouth to (width-1, height-1), one line per row, with
+ * chars giving the character for each region type
+ */
+func (c *Cave) Format(width, height int, chars string) string {
+	var sb strings.Builder
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sb.WriteByte(chars[c.Region(x, y)])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+/** Parse a coordinate written as x,y */
+func ParseCoordinate(s string) (Coordinate, error) {
+	var c Coordinate
+	if _, err := fmt.Sscanf(s, "%d,%d", &c.x, &c.y); err != nil {
+		return c, fmt.Errorf("Bad coordinate %q, should be x,y", s)
+	}
+	return c, nil
+}
@@ -1,228 +1,62 @@
 package main
 
 import (
-	_ "flag"
+	"flag"
 	"fmt"
-	"math"
-	_ "os"
-	_ "runtime/pprof"
 )
 
-// Problem input
-const DEPTH = 8787
-const TARGET_X = 10
-const TARGET_Y = 725
-
-// Example input
-// const DEPTH = 510
-// const TARGET_X = 10
-// const TARGET_Y = 10
-
-func NewCave(width, height int) [][]int64 {
-	c := make([][]int64, 0)
-	for x := 0; x < width; x++ {
-		c = append(c, make([]int64, height))
-	}
-	return c
-}
-
-type Tool int;
-const (
-	None Tool = iota
-	Climb
-	Torch
-)
-
-type GraphNode struct {
-	// each node represents a state defined by the location, and the tool 
-	// equipped
-	x int
-	y int
-	tool Tool
-
-	distance int // The shorted time found so far to reach this node
-	edges map[*GraphNode]int // node is the key, cost of traversing is value 
-}
-
-type NodeState struct {
-	x int
-	y int
-	tool Tool
-}
-
-type NodeCollection map[NodeState]*GraphNode;
-
-func (array *NodeCollection) FindOrCreate(x, y int, tool Tool) *GraphNode {
-	state := NodeState{x, y, tool}
-	foundNode, found := (*array)[state]
-	if found {
-		return foundNode
-	}
-	n := GraphNode{}
-	n.x = x
-	n.y = y
-	n.tool = tool
-	n.distance = math.MaxInt32
-	n.edges = make(map[*GraphNode]int)
-	(*array)[state] = &n
-	return &n
-}
-
-func ToolAllowed(gridType int, tool Tool) bool {
-	switch gridType {
-	case 0:
-		return tool == Torch || tool == Climb
-	case 1:
-		return tool == None || tool == Climb
-	case 2:
-		return tool == None || tool == Torch
-	default:
-		return false
-	}
-}
-
-const SWITCH_TIME = 7
-const MOVE_TIME = 1
-
-// Traverse outward, assuming we've just reached node after a time 
-// distance has elapsed
-func AnnotateDistance(distance int, node *GraphNode) {
-	distanceQ := make([]int, 0)
-	nodeQ := make([]*GraphNode, 0)
-	
-	distanceQ = append(distanceQ, distance)
-	nodeQ = append(nodeQ, node)
-	idx := 0
-	for idx < len(distanceQ) {
-		distance = distanceQ[idx]
-		node = nodeQ[idx]
-		idx++
-		if distance >= node.distance {
-			continue
-		}
-		node.distance = distance
-		for next, cost := range node.edges {
-			distanceQ = append(distanceQ, distance+cost)
-			nodeQ = append(nodeQ, next)
-		}
-	}
-}
-
 func main() {
+	// Problem input. The example is -depth 510 -target 10,10
+	depth := flag.Int("depth", 8787, "Depth of the cave")
+	targetStr := flag.String("target", "10,725", "Where the target is, as x,y")
+	rulesFile := flag.String("rules", "cave_rules.txt", "The rules for getting around the cave")
+	maxTime := flag.Int("maxtime", 100000, "Give up on routes which take longer than this")
+	verbose := flag.Bool("verbose", false, "Print every step of the route")
+	showMap := flag.Bool("map", false, "Print the cave with the route drawn over it")
+	timeline := flag.Bool("timeline", false, "Print when tools were switched")
+	pngFile := flag.String("png", "", "Draw the cave and route to this PNG file")
+	scale := flag.Int("scale", 4, "Pixels per region in the PNG")
+	flag.Parse()
+
+	target, err := ParseCoordinate(*targetStr)
+	if err != nil {
+		panic(err)
+	}
+	rules, err := LoadCaveRules(*rulesFile)
+	if err != nil {
+		panic(err)
+	}
+	cave := NewCave(*depth, target, rules.RegionTypes())
+
+	fmt.Print(cave.Format(target.x + 1, target.y + 1, rules.RegionChars()))
+	fmt.Println("Part 1\n------")
+	fmt.Println("Risk: ", cave.Risk())
 
-	// var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
-
-    // flag.Parse()
-    // if *cpuprofile != "" {
-    //     f, err := os.Create(*cpuprofile)
-    //     if err != nil {
-    //         panic(err)
-    //     }
-    //     pprof.StartCPUProfile(f)
-    //     defer pprof.StopCPUProfile()
-    // }
-
-	// For part 2, we need to compute the map beyond the target, as this may be 
-	// part of the fastest route. This amount of extra is a total SWAG. 
-	width := TARGET_X * 5
-	height := TARGET_Y * 2
-	cave := NewCave(width, height)
-
-	// Logic assumes TARGET location cannot fall on first row/col
-	if TARGET_X == 0 || TARGET_Y == 0 {
-		panic("Unhandled target position")
-	}
-
-	for x := 1; x < width; x++ {
-		cave[x][0] = (int64(x) * 16807)
+	// The search works out as much of the cave as it needs as it goes
+	rescue := FindRescue(cave, rules, *maxTime)
+	if rescue == nil {
+		fmt.Printf("There's no way to reach the target within %d seconds\n", *maxTime)
+		return
 	}
-	for y := 1; y < height; y++ {
-		cave[0][y] = (int64(y) * 48271)
+	if *verbose {
+		rescue.PrintRoute()
 	}
+	moves, switches := rescue.Counts()
+	fmt.Println("Part 2\n------")
+	fmt.Printf("Explored %d states\n", rescue.explored)
+	fmt.Printf("Route has %d moves and %d tool switches\n", moves, switches)
+	fmt.Printf("It took %d seconds to reach target\n", rescue.time)
 
-	for x := 1; x < width; x++ {
-		for y := 1; y < height; y++ {
-			if x != TARGET_X || y != TARGET_Y {
-				geoA := (cave[x-1][y] + DEPTH) % 20183
-				geoB := (cave[x][y-1] + DEPTH) % 20183
-				cave[x][y] = ((geoA % 20183)  * geoB) % 20183
-			}
-		}
+	if *showMap {
+		fmt.Println(rescue.Legend())
+		fmt.Print(rescue.FormatRoute(cave))
 	}
-
-	// Convert to region type
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			cave[x][y] = ((cave[x][y] + DEPTH) % 20183) % 3
-		}
-	}
-	// Sum the "risk" for part 1
-	risk := 0
-	for y := 0; y <= TARGET_Y; y++ {
-		for x := 0; x <= TARGET_X; x++ {
-			category := cave[x][y]
-			switch category {
-			case 0:
-				fmt.Printf(".")
-			case 1:
-				fmt.Printf("=")
-			case 2:
-				fmt.Printf("|")
-			default:
-				fmt.Println("Unknown category ", category, cave[x][y], x, y)
-				panic("Unknown value")
-			}
-			risk += int(category)
-			cave[x][y] = category
-		}
-		fmt.Printf("\n")
+	if *timeline {
+		rescue.PrintTimeline(72)
 	}
-
-	fmt.Println("Part 1\n------")
-	fmt.Println("Risk: ", risk)
-
-	// Build a graph of all possible states
-	graphNodes := make(NodeCollection, 0)
-	startNode := graphNodes.FindOrCreate(0, 0, Torch)
-	targetNode := graphNodes.FindOrCreate(TARGET_X, TARGET_Y, Torch)
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			gridType := int(cave[x][y])
-
-			nodes := make([]*GraphNode, 0, 2)
-
-			for _, tool := range []Tool{None, Climb, Torch} {
-				if ToolAllowed(gridType, tool) {
-					nodes = append(nodes, graphNodes.FindOrCreate(x, y, tool))
-				}
-			}
-			// Note: len(nodes) will always be 2
-			// Add edges for switching tools within this room
-			nodes[0].edges[nodes[1]] = SWITCH_TIME
-			nodes[1].edges[nodes[0]] = SWITCH_TIME
-			
-			// Find allowable neighbors and add edges for those
-			for _, n := range nodes {
-				for _, delta := range [][2]int{{0, 1}, {0, -1}, {1, 0}, {-1, 0}} {
-					nX := n.x + delta[0]
-					nY := n.y + delta[1]
-					if nX < 0 || nX >= width || nY < 0 || nY >= height {
-						continue
-					}
-					nType := int(cave[nX][nY])
-					if ToolAllowed(nType, n.tool) {
-						// Add edge to transition to neighboring room with same tool
-						nNode := graphNodes.FindOrCreate(nX, nY, n.tool)
-						n.edges[nNode] = MOVE_TIME
-					}
-				}
-			}
+	if *pngFile != "" {
+		if err := WritePNG(*pngFile, rescue.Image(cave, *scale)); err != nil {
+			panic(err)
 		}
 	}
-	fmt.Printf("Graph constructed\n")
-	fmt.Printf("Created %d nodes\n", len(graphNodes))
-
-	// Now we have a graph. Traverse it to populate all nodes with a distance from start. 
-	AnnotateDistance(0, startNode)
-	fmt.Printf("It took %d seconds to reach target\n", targetNode.distance)
-}
\ No newline at end of file
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+)
+
+/** What the letters on the route map mean */
+func (r *Rescue) Legend() string {
+	tools := make([]string, len(r.rules.tools))
+	for i, t := range r.rules.tools {
+		tools[i] = fmt.Sprintf("%c %s", t.char, t.name)
+	}
+	return "M mouth, T target, route shows the tool in hand: " + strings.Join(tools, ", ") + " (upper case where switched)"
+}
+
+/** The rectangle worth drawing: from the mouth to past both the target and
+ * the furthest the route goes, with a region spare on each side
+ */
+func (r *Rescue) bounds(cave *Cave) (width, height int) {
+	width, height = cave.Target().x + 2, cave.Target().y + 2
+	for _, step := range r.route {
+		if step.pos.x + 2 > width {
+			width = step.pos.x + 2
+		}
+		if step.pos.y + 2 > height {
+			height = step.pos.y + 2
+		}
+	}
+	return width, height
+}
+
+/** The cave map with the route drawn over it */
+func (r *Rescue) FormatRoute(cave *Cave) string {
+	width, height := r.bounds(cave)
+	rows := strings.Split(cave.Format(width, height, r.rules.RegionChars()), "\n")
+	cells := make([][]byte, height)
+	for y := range cells {
+		cells[y] = []byte(rows[y])
+	}
+	for _, step := range r.route {
+		c := r.rules.tools[step.tool].char
+		if step.kind == Switch {
+			c = strings.ToUpper(string(c))[0]
+		}
+		cells[step.pos.y][step.pos.x] = c
+	}
+	cells[0][0] = 'M'
+	cells[cave.Target().y][cave.Target().x] = 'T'
+
+	var sb strings.Builder
+	for _, row := range cells {
+		sb.Write(row)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// A stretch of the route spent holding one tool
+type toolSpan struct {
+	tool Tool
+	start int
+	end int
+	switchAt Coordinate // Where the switch to this tool happened
+}
+
+func (r *Rescue) toolSpans() []toolSpan {
+	spans := []toolSpan{{r.route[0].tool, 0, r.time, r.route[0].pos}}
+	for _, step := range r.route[1:] {
+		if step.kind != Switch {
+			continue
+		}
+		// The switch itself is spent with the old tool
+		spans[len(spans)-1].end = step.time
+		spans = append(spans, toolSpan{step.tool, step.time, r.time, step.pos})
+	}
+	return spans
+}
+
+/** Print when each tool was switched to, and a bar across the whole rescue
+ * showing which was in hand, width characters long
+ */
+func (r *Rescue) PrintTimeline(width int) {
+	spans := r.toolSpans()
+	for i, span := range spans {
+		verb := "start with"
+		if i > 0 {
+			verb = "switch to"
+		}
+		fmt.Printf("%5d-%-5d %-10s %-13s at %d,%d\n", span.start, span.end, verb, r.rules.ToolName(span.tool), span.switchAt.x, span.switchAt.y)
+	}
+	if r.time == 0 {
+		return
+	}
+	bar := make([]byte, width)
+	for i := range bar {
+		t := i * r.time / width
+		for _, span := range spans {
+			if t >= span.start && t < span.end {
+				bar[i] = r.rules.tools[span.tool].char
+			}
+		}
+	}
+	fmt.Printf("0 %s %d\n", bar, r.time)
+}
+
+// Colours are handed out to region types and tools in the order the rules
+// list them, going round again if there are more than there are colours
+var (
+	regionColors = []color.RGBA{
+		{0x90, 0x90, 0x90, 0xff},
+		{0x40, 0x70, 0xc0, 0xff},
+		{0x50, 0x40, 0x30, 0xff},
+		{0xc0, 0xe8, 0xf0, 0xff},
+		{0x70, 0x50, 0x90, 0xff},
+	}
+	toolColors = []color.RGBA{
+		{0xff, 0xff, 0xff, 0xff},
+		{0x30, 0xe0, 0x30, 0xff},
+		{0xff, 0xa0, 0x00, 0xff},
+		{0xff, 0xff, 0x40, 0xff},
+	}
+	switchColor = color.RGBA{0xff, 0x00, 0xff, 0xff}
+	endsColor = color.RGBA{0xff, 0x00, 0x00, 0xff}
+)
+
+/** Draw the cave with the route over it in the colour of the tool in hand,
+ * and each switch marked
+ */
+func (r *Rescue) Image(cave *Cave, scale int) *image.RGBA {
+	width, height := r.bounds(cave)
+	img := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+	fill := func(x, y int, col color.RGBA) {
+		for j := 0; j < scale; j++ {
+			for i := 0; i < scale; i++ {
+				img.SetRGBA(x*scale+i, y*scale+j, col)
+			}
+		}
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fill(x, y, regionColors[int(cave.Region(x, y)) % len(regionColors)])
+		}
+	}
+	for _, step := range r.route {
+		fill(step.pos.x, step.pos.y, toolColors[int(step.tool) % len(toolColors)])
+	}
+	for _, step := range r.route {
+		if step.kind == Switch {
+			fill(step.pos.x, step.pos.y, switchColor)
+		}
+	}
+	fill(0, 0, endsColor)
+	fill(cave.Target().x, cave.Target().y, endsColor)
+	return img
+}
+
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+/* Finding the fastest way to the target
+ *
+ * Each state is a region plus the tool in hand. Rather than building every
+ * state up front, A* asks the cave about neighbouring regions as it reaches
+ * them, so the cave only gets worked out as far as the search wanders.
+ */
+
+type State struct {
+	pos Coordinate
+	tool Tool
+}
+
+type StepKind int
+const (
+	Enter StepKind = iota // Standing at the mouth of the cave
+	Move
+	Switch
+)
+
+// One step of a route, and the time by the end of it
+type Step struct {
+	State
+	kind StepKind
+	time int
+}
+
+type Rescue struct {
+	rules *CaveRules
+	time int
+	route []Step
+	explored int // Number of states taken off the queue
+}
+
+type searchItem struct {
+	state State
+	time int
+	estimate int // Time so far plus the heuristic
+}
+
+type searchQueue []searchItem
+
+func (q searchQueue) Len() int { return len(q) }
+func (q searchQueue) Less(i, j int) bool { return q[i].estimate < q[j].estimate }
+func (q searchQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *searchQueue) Push(x interface{}) { *q = append(*q, x.(searchItem)) }
+func (q *searchQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+/** A lower bound on the time left from s: every region needs a move, and
+ * the starting tool has to be back in hand at the end
+ */
+func heuristic(rules *CaveRules, s State, target Coordinate) int {
+	h := (absInt(target.x - s.pos.x) + absInt(target.y - s.pos.y)) * rules.minMoveCost()
+	if s.tool != rules.start {
+		h += rules.minSwitchTo(rules.start)
+	}
+	return h
+}
+
+/** Call f with every state reachable from s in one step, and its cost */
+func neighbours(cave *Cave, rules *CaveRules, s State, f func(next State, kind StepKind, cost int)) {
+	region := cave.Region(s.pos.x, s.pos.y)
+	for tool := Tool(0); int(tool) < len(rules.tools); tool++ {
+		if tool == s.tool || !rules.ToolAllowed(region, tool) {
+			continue
+		}
+		if cost, ok := rules.SwitchCost(s.tool, tool); ok {
+			f(State{s.pos, tool}, Switch, cost)
+		}
+	}
+	for _, delta := range [][2]int{{0, 1}, {0, -1}, {1, 0}, {-1, 0}} {
+		next := Coordinate{s.pos.x + delta[0], s.pos.y + delta[1]}
+		if next.x < 0 || next.y < 0 {
+			continue
+		}
+		nextRegion := cave.Region(next.x, next.y)
+		if rules.ToolAllowed(nextRegion, s.tool) {
+			f(State{next, s.tool}, Move, rules.MoveCost(nextRegion))
+		}
+	}
+}
+
+/** Find the fastest way from the mouth to the target, starting and finishing
+ * with the rules' starting tool. The cave goes on forever, so the search
+ * gives up on routes which can't get there within maxTime, and returns nil
+ * if there are none.
+ */
+func FindRescue(cave *Cave, rules *CaveRules, maxTime int) *Rescue {
+	start := State{Coordinate{0, 0}, rules.start}
+	goal := State{cave.Target(), rules.start}
+
+	best := map[State]int{start: 0}
+	from := make(map[State]Step) // How each state was best reached
+	done := make(map[State]bool)
+	queue := &searchQueue{{start, 0, heuristic(rules, start, goal.pos)}}
+	rescue := &Rescue{rules: rules}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(searchItem)
+		if done[item.state] {
+			continue
+		}
+		done[item.state] = true
+		rescue.explored++
+		if item.state == goal {
+			break
+		}
+		neighbours(cave, rules, item.state, func(next State, kind StepKind, cost int) {
+			t := item.time + cost
+			estimate := t + heuristic(rules, next, goal.pos)
+			if estimate > maxTime {
+				return
+			}
+			if prev, ok := best[next]; ok && prev <= t {
+				return
+			}
+			best[next] = t
+			from[next] = Step{item.state, kind, t}
+			heap.Push(queue, searchItem{next, t, estimate})
+		})
+	}
+
+	if !done[goal] {
+		return nil
+	}
+
+	// Walk back from the goal to get the route
+	rescue.time = best[goal]
+	s := goal
+	for s != start {
+		prev := from[s]
+		rescue.route = append(rescue.route, Step{s, prev.kind, prev.time})
+		s = prev.State
+	}
+	rescue.route = append(rescue.route, Step{start, Enter, 0})
+	for i, j := 0, len(rescue.route)-1; i < j; i, j = i+1, j-1 {
+		rescue.route[i], rescue.route[j] = rescue.route[j], rescue.route[i]
+	}
+	return rescue
+}
+
+/** Number of moves and tool switches along the route */
+func (r *Rescue) Counts() (moves, switches int) {
+	for _, step := range r.route {
+		switch step.kind {
+		case Move:
+			moves++
+		case Switch:
+			switches++
+		}
+	}
+	return moves, switches
+}
+
+func (r *Rescue) PrintRoute() {
+	for _, step := range r.route {
+		switch step.kind {
+		case Enter:
+			fmt.Printf("%5d: enter at %d,%d with the %s\n", step.time, step.pos.x, step.pos.y, r.rules.ToolName(step.tool))
+		case Move:
+			fmt.Printf("%5d: move to %d,%d\n", step.time, step.pos.x, step.pos.y)
+		case Switch:
+			fmt.Printf("%5d: switch to %s\n", step.time, r.rules.ToolName(step.tool))
+		}
+	}
+}
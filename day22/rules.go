@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/* Cave rules
+ *
+ * Which tools can be used in which regions, and how long it takes to move
+ * and switch tools, are read from a rules file:
+ *
+ *     tool torch t
+ *     region rocky . 1 climbing torch
+ *     switch * * 7
+ *     switch neither torch 10
+ *     start torch
+ *
+ * Tools are given a name and a letter to draw them with. Regions are listed
+ * in order of erosion level: with n regions, a region's type is its erosion
+ * level mod n. Each has a character to draw it with, the time it takes to
+ * move into it, and the tools which can be used in it. Switch lines give the
+ * time to switch from one tool to another, with * matching any tool, and
+ * later lines override earlier ones. Pairs with no switch line can't be
+ * switched between. The start line names the tool in hand at the mouth,
+ * which also has to be in hand at the target.
+ *
+ * Blank lines and lines starting with // are ignored.
+ */
+
+type Tool int
+
+type ToolRule struct {
+	name string
+	char byte
+}
+
+type RegionRule struct {
+	name string
+	char byte
+	moveCost int
+	tools map[Tool]bool
+}
+
+type CaveRules struct {
+	tools []ToolRule
+	regions []RegionRule
+	switchCost [][]int // By from then to tool, -1 if not allowed
+	start Tool
+}
+
+func (r *CaveRules) RegionTypes() int { return len(r.regions) }
+
+/** Characters for each region type, in order */
+func (r *CaveRules) RegionChars() string {
+	chars := make([]byte, len(r.regions))
+	for i, region := range r.regions {
+		chars[i] = region.char
+	}
+	return string(chars)
+}
+
+func (r *CaveRules) ToolName(t Tool) string {
+	return r.tools[t].name
+}
+
+func (r *CaveRules) ToolAllowed(region Region, tool Tool) bool {
+	return r.regions[region].tools[tool]
+}
+
+func (r *CaveRules) MoveCost(region Region) int {
+	return r.regions[region].moveCost
+}
+
+/** Time to switch between two tools. ok is false if it can't be done. */
+func (r *CaveRules) SwitchCost(from, to Tool) (cost int, ok bool) {
+	cost = r.switchCost[from][to]
+	return cost, cost >= 0
+}
+
+/** The least time it takes to move into any region */
+func (r *CaveRules) minMoveCost() int {
+	min := r.regions[0].moveCost
+	for _, region := range r.regions {
+		if region.moveCost < min {
+			min = region.moveCost
+		}
+	}
+	return min
+}
+
+/** The least time it takes to switch to a tool from any other */
+func (r *CaveRules) minSwitchTo(to Tool) int {
+	min := -1
+	for from := range r.tools {
+		if cost, ok := r.SwitchCost(Tool(from), to); ok && Tool(from) != to && (min < 0 || cost < min) {
+			min = cost
+		}
+	}
+	if min < 0 {
+		return 0
+	}
+	return min
+}
+
+func (r *CaveRules) findTool(name string) (Tool, error) {
+	for i, t := range r.tools {
+		if t.name == name {
+			return Tool(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown tool %q", name)
+}
+
+func parseCost(s string) (int, error) {
+	cost, err := strconv.Atoi(s)
+	if err != nil || cost < 0 {
+		return 0, fmt.Errorf("Bad time %q", s)
+	}
+	return cost, nil
+}
+
+func ParseCaveRules(lines []string) (*CaveRules, error) {
+	r := &CaveRules{start: -1}
+	// Switch lines are kept until the end, so they can name any tool
+	type switchLine struct {
+		lineNum int
+		fields []string
+	}
+	switches := make([]switchLine, 0)
+	for lineNum, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		fail := func(err error) (*CaveRules, error) {
+			return nil, fmt.Errorf("line %d: %v", lineNum+1, err)
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "tool":
+			if len(fields) != 3 || len(fields[2]) != 1 {
+				return fail(fmt.Errorf("should be: tool <name> <letter>"))
+			}
+			if _, err := r.findTool(fields[1]); err == nil {
+				return fail(fmt.Errorf("Tool %q defined twice", fields[1]))
+			}
+			r.tools = append(r.tools, ToolRule{fields[1], fields[2][0]})
+		case "region":
+			if len(fields) < 4 || len(fields[2]) != 1 {
+				return fail(fmt.Errorf("should be: region <name> <char> <move time> <tools...>"))
+			}
+			cost, err := parseCost(fields[3])
+			if err != nil {
+				return fail(err)
+			}
+			region := RegionRule{fields[1], fields[2][0], cost, make(map[Tool]bool)}
+			for _, name := range fields[4:] {
+				t, err := r.findTool(name)
+				if err != nil {
+					return fail(err)
+				}
+				region.tools[t] = true
+			}
+			r.regions = append(r.regions, region)
+		case "switch":
+			if len(fields) != 4 {
+				return fail(fmt.Errorf("should be: switch <from> <to> <time>"))
+			}
+			switches = append(switches, switchLine{lineNum, fields})
+		case "start":
+			if len(fields) != 2 {
+				return fail(fmt.Errorf("should be: start <tool>"))
+			}
+			t, err := r.findTool(fields[1])
+			if err != nil {
+				return fail(err)
+			}
+			r.start = t
+		default:
+			return fail(fmt.Errorf("can't parse rule %q", line))
+		}
+	}
+
+	if len(r.tools) == 0 || len(r.regions) == 0 {
+		return nil, fmt.Errorf("Rules need at least one tool and one region")
+	}
+	if r.start < 0 {
+		return nil, fmt.Errorf("Rules don't say which tool to start with")
+	}
+
+	r.switchCost = make([][]int, len(r.tools))
+	for from := range r.switchCost {
+		r.switchCost[from] = make([]int, len(r.tools))
+		for to := range r.switchCost[from] {
+			r.switchCost[from][to] = -1
+		}
+	}
+	for _, s := range switches {
+		fail := func(err error) (*CaveRules, error) {
+			return nil, fmt.Errorf("line %d: %v", s.lineNum+1, err)
+		}
+		matching := func(name string) ([]Tool, error) {
+			if name == "*" {
+				all := make([]Tool, len(r.tools))
+				for i := range all {
+					all[i] = Tool(i)
+				}
+				return all, nil
+			}
+			t, err := r.findTool(name)
+			return []Tool{t}, err
+		}
+		fromTools, err := matching(s.fields[1])
+		if err != nil {
+			return fail(err)
+		}
+		toTools, err := matching(s.fields[2])
+		if err != nil {
+			return fail(err)
+		}
+		cost, err := parseCost(s.fields[3])
+		if err != nil {
+			return fail(err)
+		}
+		for _, from := range fromTools {
+			for _, to := range toTools {
+				if from != to {
+					r.switchCost[from][to] = cost
+				}
+			}
+		}
+	}
+	return r, nil
+}
+
+func LoadCaveRules(filepath string) (*CaveRules, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lines := make([]string, 0)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseCaveRules(lines)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"sort"
+)
+
+/* Index over the bots' ranges
+ *
+ * A bot's range is an octahedron, which is awkward to test boxes against.
+ * Turned into the four coordinates x+y+z, x+y-z, x-y+z and -x+y+z, though,
+ * it's just a box: a point is in range when every one of its four rotated
+ * coordinates is within r of the bot's. The bots are kept in a tree, split
+ * in half along whichever rotated coordinate their centres spread out along
+ * most, with each node knowing the rotated bounds of all the ranges under it
+ * so that whole branches can be skipped.
+ */
+
+type rotated [4]int
+
+func rotate(p Point) rotated {
+	x, y, z := p[0], p[1], p[2]
+	return rotated{x + y + z, x + y - z, x - y + z, -x + y + z}
+}
+
+// Bounds in rotated coordinates, min and max inclusive
+type rotatedBox struct {
+	min rotated
+	max rotated
+}
+
+func (a rotatedBox) overlaps(b rotatedBox) bool {
+	for k := 0; k < 4; k++ {
+		if a.max[k] < b.min[k] || b.max[k] < a.min[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a rotatedBox) contains(u rotated) bool {
+	for k := 0; k < 4; k++ {
+		if u[k] < a.min[k] || u[k] > a.max[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *rotatedBox) extend(b rotatedBox) {
+	for k := 0; k < 4; k++ {
+		if b.min[k] < a.min[k] {
+			a.min[k] = b.min[k]
+		}
+		if b.max[k] > a.max[k] {
+			a.max[k] = b.max[k]
+		}
+	}
+}
+
+func botRange(b Nanobot) rotatedBox {
+	c := rotate(Point{b.x, b.y, b.z})
+	var rb rotatedBox
+	for k := 0; k < 4; k++ {
+		rb.min[k] = c[k] - b.r
+		rb.max[k] = c[k] + b.r
+	}
+	return rb
+}
+
+/** The rotated bounds of a box. Not every point inside them is in the box,
+ * but every point in the box is inside them.
+ */
+func (b Box) rotatedBounds() rotatedBox {
+	// Signs of x, y and z in each rotated coordinate
+	signs := [4][3]int{{1, 1, 1}, {1, 1, -1}, {1, -1, 1}, {-1, 1, 1}}
+	var rb rotatedBox
+	for k, sign := range signs {
+		for i := 0; i < 3; i++ {
+			if sign[i] > 0 {
+				rb.min[k] += b.min[i]
+				rb.max[k] += b.max[i]
+			} else {
+				rb.min[k] -= b.max[i]
+				rb.max[k] -= b.min[i]
+			}
+		}
+	}
+	return rb
+}
+
+const indexLeafSize = 8
+
+type indexNode struct {
+	reach rotatedBox // Covers the ranges of every bot under this node
+	centres rotatedBox // Covers the centres of every bot under this node
+	children [2]*indexNode
+	bots []int // Only for leaves
+}
+
+type BotIndex struct {
+	bots []Nanobot
+	root *indexNode
+}
+
+func NewBotIndex(bots []Nanobot) *BotIndex {
+	ix := &BotIndex{bots: bots}
+	if len(bots) == 0 {
+		return ix
+	}
+	ids := make([]int, len(bots))
+	for i := range ids {
+		ids[i] = i
+	}
+	ix.root = ix.build(ids)
+	return ix
+}
+
+func (ix *BotIndex) build(ids []int) *indexNode {
+	n := &indexNode{}
+	for i, id := range ids {
+		b := ix.bots[id]
+		c := rotate(Point{b.x, b.y, b.z})
+		if i == 0 {
+			n.reach = botRange(b)
+			n.centres = rotatedBox{c, c}
+			continue
+		}
+		n.reach.extend(botRange(b))
+		n.centres.extend(rotatedBox{c, c})
+	}
+	if len(ids) <= indexLeafSize {
+		n.bots = ids
+		return n
+	}
+
+	axis := 0
+	for k := 1; k < 4; k++ {
+		if n.centres.max[k] - n.centres.min[k] > n.centres.max[axis] - n.centres.min[axis] {
+			axis = k
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ix.bots[ids[i]], ix.bots[ids[j]]
+		return rotate(Point{a.x, a.y, a.z})[axis] < rotate(Point{b.x, b.y, b.z})[axis]
+	})
+	half := len(ids) / 2
+	n.children[0] = ix.build(ids[:half])
+	n.children[1] = ix.build(ids[half:])
+	return n
+}
+
+/** Call f with the index of every bot which reaches some point in the box */
+func (ix *BotIndex) eachInBox(b Box, f func(id int)) {
+	if ix.root == nil {
+		return
+	}
+	bounds := b.rotatedBounds()
+	var rec func(n *indexNode)
+	rec = func(n *indexNode) {
+		if !n.reach.overlaps(bounds) {
+			return
+		}
+		if n.bots == nil {
+			rec(n.children[0])
+			rec(n.children[1])
+			return
+		}
+		for _, id := range n.bots {
+			bot := ix.bots[id]
+			if b.Distance(Point{bot.x, bot.y, bot.z}) <= bot.r {
+				f(id)
+			}
+		}
+	}
+	rec(ix.root)
+}
+
+/** Number of bots which reach some point in the box */
+func (ix *BotIndex) CountBox(b Box) int {
+	count := 0
+	ix.eachInBox(b, func(int) { count++ })
+	return count
+}
+
+/** Indices of the bots which reach some point in the box */
+func (ix *BotIndex) BotsInBox(b Box) []int {
+	ids := make([]int, 0)
+	ix.eachInBox(b, func(id int) { ids = append(ids, id) })
+	sort.Ints(ids)
+	return ids
+}
+
+func (ix *BotIndex) CountPoint(p Point) int {
+	return ix.CountBox(Box{p, p})
+}
+
+func (ix *BotIndex) BotsAtPoint(p Point) []int {
+	return ix.BotsInBox(Box{p, p})
+}
+
+/** Number of bots whose centres are in range of bot b */
+func (ix *BotIndex) CentresInRange(b Nanobot) int {
+	if ix.root == nil {
+		return 0
+	}
+	reach := botRange(b)
+	count := 0
+	var rec func(n *indexNode)
+	rec = func(n *indexNode) {
+		if !n.centres.overlaps(reach) {
+			return
+		}
+		if n.bots == nil {
+			rec(n.children[0])
+			rec(n.children[1])
+			return
+		}
+		for _, id := range n.bots {
+			other := ix.bots[id]
+			if reach.contains(rotate(Point{other.x, other.y, other.z})) {
+				count++
+			}
+		}
+	}
+	rec(ix.root)
+	return count
+}
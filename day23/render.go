@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A plane with one coordinate held fixed
+type Slice struct {
+	axis int // 0, 1 or 2 for x, y or z
+	value int
+}
+
+/** Parse a plane like z=1234. With just the axis, the plane goes through
+ * point instead.
+ */
+func ParseSlice(s string, point Point) (Slice, error) {
+	parts := strings.SplitN(s, "=", 2)
+	axis := strings.Index("xyz", parts[0])
+	if len(parts[0]) != 1 || axis < 0 {
+		return Slice{}, fmt.Errorf("Bad slice %q, should be like z=1234", s)
+	}
+	sl := Slice{axis, point[axis]}
+	if len(parts) == 2 {
+		v, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return Slice{}, fmt.Errorf("Bad slice %q, should be like z=1234", s)
+		}
+		sl.value = v
+	}
+	return sl, nil
+}
+
+/** The two axes which vary across the slice, as image x then image y */
+func (sl Slice) axes() (int, int) {
+	switch sl.axis {
+	case 0:
+		return 1, 2
+	case 1:
+		return 0, 2
+	}
+	return 0, 1
+}
+
+/** Shade for a count, going from black at the lowest through red and
+ * yellow to white at the highest
+ */
+func coverageColor(count, minCount, maxCount int) color.RGBA {
+	t := 0.0
+	if maxCount > minCount {
+		t = float64(count - minCount) / float64(maxCount - minCount)
+	}
+	clamp := func(v float64) uint8 {
+		if v < 0 {
+			return 0
+		} else if v > 1 {
+			return 0xff
+		}
+		return uint8(v * 0xff)
+	}
+	return color.RGBA{clamp(3*t), clamp(3*t - 1), clamp(3*t - 2), 0xff}
+}
+
+var markColor = color.RGBA{0x00, 0xc0, 0xff, 0xff}
+
+/** Draw how many bots reach each point across the slice, over the part of
+ * it from min to max (only the two axes in the plane are used), with
+ * resolution pixels along the longer side. If mark lies in the slice it gets
+ * a crosshair. Colours are scaled between the lowest and highest counts in
+ * the slice, and the highest is returned along with the image.
+ */
+func (ix *BotIndex) SliceImage(sl Slice, min, max Point, resolution int, mark Point) (*image.RGBA, int) {
+	ax, ay := sl.axes()
+	spanX, spanY := max[ax] - min[ax] + 1, max[ay] - min[ay] + 1
+	span := spanX
+	if spanY > span {
+		span = spanY
+	}
+	// Distance between samples, rounded up so the whole region fits
+	step := (span + resolution - 1) / resolution
+	if step < 1 {
+		step = 1
+	}
+	width, height := (spanX + step - 1) / step, (spanY + step - 1) / step
+
+	counts := make([]int, width*height)
+	minCount, maxCount := len(ix.bots), 0
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			var p Point
+			p[sl.axis] = sl.value
+			p[ax] = min[ax] + px*step + step/2
+			p[ay] = min[ay] + py*step + step/2
+			c := ix.CountPoint(p)
+			counts[py*width + px] = c
+			if c > maxCount {
+				maxCount = c
+			}
+			if c < minCount {
+				minCount = c
+			}
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			img.SetRGBA(px, py, coverageColor(counts[py*width + px], minCount, maxCount))
+		}
+	}
+	if mark[sl.axis] == sl.value {
+		mx, my := (mark[ax] - min[ax]) / step, (mark[ay] - min[ay]) / step
+		for d := -6; d <= 6; d++ {
+			if d > -2 && d < 2 {
+				continue
+			}
+			img.SetRGBA(mx + d, my, markColor)
+			img.SetRGBA(mx, my + d, markColor)
+		}
+	}
+	return img, maxCount
+}
+
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
+
+/** Write the next n best points from the search as CSV, best first */
+func (s *PointSearch) WriteMaximaCSV(path string, n int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"rank", "x", "y", "z", "score", "distance"})
+	for rank := 1; rank <= n; rank++ {
+		p, score, ok := s.Next()
+		if !ok {
+			break
+		}
+		dist := Box{p, p}.Distance(Point{})
+		w.Write([]string{strconv.Itoa(rank), strconv.Itoa(p[0]), strconv.Itoa(p[1]), strconv.Itoa(p[2]), strconv.Itoa(score), strconv.Itoa(dist)})
+	}
+	w.Flush()
+	return w.Error()
+}
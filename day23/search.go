@@ -0,0 +1,148 @@
+package main
+
+import (
+	"container/heap"
+)
+
+/* Exact search for the best point
+ *
+ * Space is split up into boxes, each scored by how many bots reach any point
+ * inside it. That's an upper bound on the score of every point in the box.
+ * Boxes come off a priority queue highest bound first, nearest the origin
+ * next, and get split in eight until they're single points. A single point's
+ * bound is its actual score, so the first one off the queue has the best
+ * score there is, and is the nearest to the origin of any point with that
+ * score. Carrying on popping gives the next best points, in order.
+ */
+
+type Point [3]int
+
+// A box of points from min to max, both inclusive
+type Box struct {
+	min Point
+	max Point
+}
+
+func (b Box) IsPoint() bool {
+	return b.min == b.max
+}
+
+/** Manhattan distance from p to the nearest point in the box */
+func (b Box) Distance(p Point) int {
+	d := 0
+	for i := 0; i < 3; i++ {
+		if p[i] < b.min[i] {
+			d += b.min[i] - p[i]
+		} else if p[i] > b.max[i] {
+			d += p[i] - b.max[i]
+		}
+	}
+	return d
+}
+
+/** Split the box in half along each axis which is more than one point wide */
+func (b Box) Split() []Box {
+	boxes := []Box{b}
+	for i := 0; i < 3; i++ {
+		if b.min[i] == b.max[i] {
+			continue
+		}
+		mid := b.min[i] + (b.max[i] - b.min[i]) / 2
+		next := make([]Box, 0, 2*len(boxes))
+		for _, box := range boxes {
+			lo, hi := box, box
+			lo.max[i] = mid
+			hi.min[i] = mid + 1
+			next = append(next, lo, hi)
+		}
+		boxes = next
+	}
+	return boxes
+}
+
+type boxItem struct {
+	box Box
+	bound int
+	distance int // From the origin
+}
+
+type boxQueue []boxItem
+
+func (q boxQueue) Len() int { return len(q) }
+func (q boxQueue) Less(i, j int) bool {
+	if q[i].bound != q[j].bound {
+		return q[i].bound > q[j].bound
+	}
+	if q[i].distance != q[j].distance {
+		return q[i].distance < q[j].distance
+	}
+	// Points before boxes, so a point is found as soon as nothing can beat it
+	return q[i].box.IsPoint() && !q[j].box.IsPoint()
+}
+func (q boxQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *boxQueue) Push(x interface{}) { *q = append(*q, x.(boxItem)) }
+func (q *boxQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+type PointSearch struct {
+	index *BotIndex
+	queue boxQueue
+	explored int // Boxes taken off the queue
+}
+
+/** The smallest box covering every bot's position and the origin */
+func BotBounds(bots []Nanobot) Box {
+	var bounds Box
+	for _, b := range bots {
+		p := Point{b.x, b.y, b.z}
+		for i := 0; i < 3; i++ {
+			if p[i] < bounds.min[i] {
+				bounds.min[i] = p[i]
+			}
+			if p[i] > bounds.max[i] {
+				bounds.max[i] = p[i]
+			}
+		}
+	}
+	return bounds
+}
+
+/** Start a search over BotBounds. Any point outside of that can be moved
+ * inside it without getting further from the origin or any bot, so nothing
+ * is missed.
+ */
+func NewPointSearch(index *BotIndex) *PointSearch {
+	s := &PointSearch{index: index}
+	s.push(BotBounds(index.bots))
+	return s
+}
+
+func (s *PointSearch) push(b Box) {
+	heap.Push(&s.queue, boxItem{b, s.index.CountBox(b), b.Distance(Point{})})
+}
+
+/** The next best point: the one with the highest score, then the nearest to
+ * the origin, of those not already returned. ok is false once there are no
+ * more points.
+ */
+func (s *PointSearch) Next() (p Point, score int, ok bool) {
+	for s.queue.Len() > 0 {
+		item := heap.Pop(&s.queue).(boxItem)
+		s.explored++
+		if item.box.IsPoint() {
+			return item.box.min, item.bound, true
+		}
+		for _, sub := range item.box.Split() {
+			s.push(sub)
+		}
+	}
+	return Point{}, 0, false
+}
+
+func (s *PointSearch) Explored() int {
+	return s.explored
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+/* Balancing
+ *
+ * Looks for the smallest change to the groups' units, damage or initiative
+ * which changes who wins the fight, to see which groups decide it.
+ *
+ * A change to units or damage costs how much it changes the value by, as a
+ * fraction of what it was. Initiative only matters as an order, so a change
+ * to it moves a group some places up or down the order, costing the number of
+ * places as a fraction of the number of groups. A set of changes costs the
+ * sum of its changes.
+ *
+ * Each attribute of each group is first tried on its own, going up and down
+ * by doubling and then bisecting to find the smallest change which flips the
+ * winner. Fights don't always get more one sided as a value goes up, so
+ * that's not guaranteed to be the smallest, just a good guess. Then pairs of
+ * changes are tried, each either one of those smallest single changes or an
+ * attribute pushed as far as it can go, since two groups together can tip a
+ * fight that neither can on its own. Random sets of a few changes are tried
+ * too, and the cheapest pairs and random sets that flip the winner are hill
+ * climbed: changes get dropped or shrunk for as long as the winner stays
+ * flipped. The result is the cheapest flip seen, which is an upper bound on
+ * the smallest rather than a guarantee.
+ */
+
+type Attribute int
+
+const (
+	Units Attribute = iota
+	Damage
+	Initiative
+)
+
+var attributes = []Attribute{Units, Damage, Initiative}
+var attributeNames = []string{"units", "damage", "initiative"}
+
+func (at Attribute) String() string {
+	return attributeNames[at]
+}
+
+// A change to one attribute of one group. For units and damage Delta gets
+// added on, for initiative it's how many places the group moves up the
+// order (down, if it's negative).
+type Change struct {
+	Group int // Index into Armies.Groups
+	Attr Attribute
+	Delta int
+}
+
+type Perturbation []Change
+
+func (p Perturbation) without(i int) Perturbation {
+	q := make(Perturbation, 0, len(p) - 1)
+	q = append(q, p[:i]...)
+	return append(q, p[i+1:]...)
+}
+
+func (p Perturbation) withDelta(i, delta int) Perturbation {
+	q := append(Perturbation{}, p...)
+	q[i].Delta = delta
+	return q
+}
+
+// The smallest single change found for a group's attribute
+type Sensitivity struct {
+	Group int
+	Attr Attribute
+	Change Change
+	Found bool
+	Cost float64
+	RandomFlips int // Random perturbations flipping the winner which changed it
+}
+
+type Balancer struct {
+	armies *Armies
+	workers int
+	maxChange float64 // Largest cost of a single change
+	rng *rand.Rand
+	rank []int // Each group's place in the initiative order, first is 0
+	baseline Outcome
+	fights int
+}
+
+func NewBalancer(armies *Armies, workers int, maxChange float64, seed int64) *Balancer {
+	if workers < 1 {
+		workers = 1
+	}
+	b := &Balancer{armies: armies, workers: workers, maxChange: maxChange, rng: rand.New(rand.NewSource(seed))}
+	b.rank = make([]int, len(armies.Groups))
+	order := make([]int, len(armies.Groups))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return LessByAttackOrder(armies.Groups[order[i]], armies.Groups[order[j]]) })
+	for r, i := range order {
+		b.rank[i] = r
+	}
+	b.baseline = b.fight(nil)
+	return b
+}
+
+func (b *Balancer) Baseline() Outcome {
+	return b.baseline
+}
+
+func (b *Balancer) Fights() int {
+	return b.fights
+}
+
+/** What a change's size gets measured against */
+func (b *Balancer) scale(c Change) float64 {
+	g := b.armies.Groups[c.Group]
+	switch c.Attr {
+	case Units:
+		return float64(g.units)
+	case Damage:
+		if g.attackDamage > 0 {
+			return float64(g.attackDamage)
+		}
+		return 1
+	}
+	return float64(len(b.armies.Groups))
+}
+
+func (b *Balancer) ChangeCost(c Change) float64 {
+	return math.Abs(float64(c.Delta)) / b.scale(c)
+}
+
+func (b *Balancer) Cost(p Perturbation) float64 {
+	cost := 0.0
+	for _, c := range p {
+		cost += b.ChangeCost(c)
+	}
+	return cost
+}
+
+/** The largest change in one direction that an attribute can take, within
+ * maxChange and without running out of units or damage or places to move
+ */
+func (b *Balancer) limit(group int, attr Attribute, dir int) int {
+	g := b.armies.Groups[group]
+	limit := int(b.maxChange * b.scale(Change{group, attr, 0}))
+	switch {
+	case attr == Units && dir < 0 && limit > g.units - 1:
+		limit = g.units - 1
+	case attr == Damage && dir < 0 && limit > g.attackDamage:
+		limit = g.attackDamage
+	case attr == Initiative && dir > 0 && limit > b.rank[group]:
+		limit = b.rank[group]
+	case attr == Initiative && dir < 0 && limit > len(b.rank) - 1 - b.rank[group]:
+		limit = len(b.rank) - 1 - b.rank[group]
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	return limit
+}
+
+/** Copies of the groups with the changes made */
+func (b *Balancer) apply(p Perturbation) []*Group {
+	groups := CopyGroups(b.armies.Groups)
+	moved := false
+	keys := make([]int, len(groups))
+	for i := range keys {
+		keys[i] = 2 * b.rank[i]
+	}
+	for _, c := range p {
+		g := groups[c.Group]
+		switch c.Attr {
+		case Units:
+			g.units += c.Delta
+			if g.units < 1 {
+				g.units = 1
+			}
+		case Damage:
+			g.attackDamage += c.Delta
+			if g.attackDamage < 0 {
+				g.attackDamage = 0
+			}
+		case Initiative:
+			// Land in between the groups either side of the new place
+			sign := 1
+			if c.Delta < 0 {
+				sign = -1
+			}
+			keys[c.Group] = 2 * (b.rank[c.Group] - c.Delta) - sign
+			moved = true
+		}
+	}
+	if moved {
+		// Only the order matters, so hand out initiatives from it afresh
+		order := make([]int, len(groups))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool {
+			if keys[order[i]] != keys[order[j]] {
+				return keys[order[i]] < keys[order[j]]
+			}
+			return b.rank[order[i]] < b.rank[order[j]]
+		})
+		for pos, i := range order {
+			groups[i].initiative = len(groups) - pos
+		}
+	}
+	return groups
+}
+
+func (b *Balancer) fight(p Perturbation) Outcome {
+	groups, rounds := b.armies.RunBattle(b.apply(p), nil)
+	outcome := Outcome{Winner: Stalemate, Rounds: rounds}
+	if side, over := LastSide(groups); over {
+		outcome.Winner, outcome.Units = b.armies.SideName(side), TotalUnits(groups)
+	}
+	return outcome
+}
+
+/** Whether the changes leave a different side winning. From a stalemate,
+ * any side winning counts.
+ */
+func (b *Balancer) Flips(p Perturbation) bool {
+	o := b.fight(p)
+	return o.Winner != Stalemate && o.Winner != b.baseline.Winner
+}
+
+/** Try each perturbation, a worker's worth at a time */
+func (b *Balancer) flipsAll(ps []Perturbation) []bool {
+	results := make([]bool, len(ps))
+	var wg sync.WaitGroup
+	slots := make(chan bool, b.workers)
+	for i, p := range ps {
+		wg.Add(1)
+		slots <- true
+		go func(i int, p Perturbation) {
+			defer wg.Done()
+			results[i] = b.Flips(p)
+			<-slots
+		}(i, p)
+	}
+	wg.Wait()
+	b.fights += len(ps)
+	return results
+}
+
+/** The smallest change in one direction to one attribute which flips the
+ * winner, found by doubling and then bisecting. Also returns the number of
+ * fights it took.
+ */
+func (b *Balancer) smallestFlip(group int, attr Attribute, dir int) (Change, bool, int) {
+	limit := b.limit(group, attr, dir)
+	fights := 0
+	flips := func(m int) bool {
+		fights++
+		return b.Flips(Perturbation{{group, attr, dir * m}})
+	}
+	if limit == 0 {
+		return Change{}, false, fights
+	}
+	lo, hi := 0, 0
+	for m := 1; hi == 0; m *= 2 {
+		if m > limit {
+			m = limit
+		}
+		if flips(m) {
+			hi = m
+		} else if m == limit {
+			return Change{}, false, fights
+		} else {
+			lo = m
+		}
+	}
+	for hi - lo > 1 {
+		mid := lo + (hi - lo) / 2
+		if flips(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return Change{group, attr, dir * hi}, true, fights
+}
+
+/** Find the smallest single change for every attribute of every group */
+func (b *Balancer) Sensitivities() []Sensitivity {
+	type job struct {
+		group int
+		attr Attribute
+		dir int
+	}
+	jobs := make([]job, 0)
+	for g := range b.armies.Groups {
+		for _, attr := range attributes {
+			jobs = append(jobs, job{g, attr, 1}, job{g, attr, -1})
+		}
+	}
+
+	changes := make([]Change, len(jobs))
+	found := make([]bool, len(jobs))
+	fights := make([]int, len(jobs))
+	var wg sync.WaitGroup
+	slots := make(chan bool, b.workers)
+	for i, j := range jobs {
+		wg.Add(1)
+		slots <- true
+		go func(i int, j job) {
+			defer wg.Done()
+			changes[i], found[i], fights[i] = b.smallestFlip(j.group, j.attr, j.dir)
+			<-slots
+		}(i, j)
+	}
+	wg.Wait()
+
+	sens := make([]Sensitivity, 0, len(jobs) / 2)
+	for i := 0; i < len(jobs); i += 2 {
+		s := Sensitivity{Group: jobs[i].group, Attr: jobs[i].attr}
+		for _, k := range []int{i, i + 1} {
+			b.fights += fights[k]
+			if found[k] && (!s.Found || b.ChangeCost(changes[k]) < s.Cost) {
+				s.Change, s.Found, s.Cost = changes[k], true, b.ChangeCost(changes[k])
+			}
+		}
+		sens = append(sens, s)
+	}
+	return sens
+}
+
+/** A random change to a random attribute, anywhere from 1% to all of
+ * maxChange, log uniformly. ok is false if the attribute can't change.
+ */
+func (b *Balancer) randomChange() (Change, bool) {
+	group := b.rng.Intn(len(b.armies.Groups))
+	attr := attributes[b.rng.Intn(len(attributes))]
+	dir := 1 - 2 * b.rng.Intn(2)
+	if b.limit(group, attr, dir) == 0 {
+		dir = -dir
+	}
+	limit := b.limit(group, attr, dir)
+	if limit == 0 {
+		return Change{}, false
+	}
+	m := int(math.Round(float64(limit) * math.Pow(100, -b.rng.Float64())))
+	if m < 1 {
+		m = 1
+	}
+	return Change{group, attr, dir * m}, true
+}
+
+/** A handful of random changes, no two to the same attribute of a group */
+func (b *Balancer) randomPerturbation() Perturbation {
+	n := 1 + b.rng.Intn(3)
+	p := make(Perturbation, 0, n)
+	for tries := 0; len(p) < n && tries < 10 * n; tries++ {
+		c, ok := b.randomChange()
+		if !ok {
+			continue
+		}
+		dup := false
+		for _, other := range p {
+			dup = dup || (other.Group == c.Group && other.Attr == c.Attr)
+		}
+		if !dup {
+			p = append(p, c)
+		}
+	}
+	return p
+}
+
+/** Try random perturbations, returning the ones which flip the winner,
+ * cheapest first
+ */
+func (b *Balancer) RandomFlips(trials int) []Perturbation {
+	ps := make([]Perturbation, trials)
+	for i := range ps {
+		ps[i] = b.randomPerturbation()
+	}
+	flips := b.flipsAll(ps)
+	found := make([]Perturbation, 0)
+	for i, p := range ps {
+		if flips[i] {
+			found = append(found, p)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return b.Cost(found[i]) < b.Cost(found[j]) })
+	return found
+}
+
+/** Keep dropping or shrinking changes for as long as the winner stays
+ * flipped, taking the cheapest option each time
+ */
+func (b *Balancer) Climb(p Perturbation) Perturbation {
+	for {
+		candidates := make([]Perturbation, 0)
+		for i, c := range p {
+			candidates = append(candidates, p.without(i))
+			tried := map[int]bool{c.Delta: true, 0: true}
+			for _, d := range []int{c.Delta / 2, c.Delta * 3 / 4, c.Delta - c.Delta / abs(c.Delta)} {
+				if !tried[d] {
+					tried[d] = true
+					candidates = append(candidates, p.withDelta(i, d))
+				}
+			}
+		}
+		flips := b.flipsAll(candidates)
+		best := -1
+		for i, cand := range candidates {
+			if flips[i] && (best < 0 || b.Cost(cand) < b.Cost(candidates[best])) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return p
+		}
+		p = candidates[best]
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+/** Every pair of changes to two different attributes, each change being an
+ * attribute's smallest single flip or its largest change either way, which
+ * flips the winner, cheapest first
+ */
+func (b *Balancer) PairFlips(sens []Sensitivity) []Perturbation {
+	seeds := make([]Change, 0)
+	for _, s := range sens {
+		if s.Found {
+			seeds = append(seeds, s.Change)
+		}
+		for _, dir := range []int{1, -1} {
+			if limit := b.limit(s.Group, s.Attr, dir); limit > 0 {
+				seeds = append(seeds, Change{s.Group, s.Attr, dir * limit})
+			}
+		}
+	}
+	ps := make([]Perturbation, 0)
+	for i := range seeds {
+		for j := i + 1; j < len(seeds); j++ {
+			if seeds[i].Group != seeds[j].Group || seeds[i].Attr != seeds[j].Attr {
+				ps = append(ps, Perturbation{seeds[i], seeds[j]})
+			}
+		}
+	}
+	flips := b.flipsAll(ps)
+	found := make([]Perturbation, 0)
+	for i, p := range ps {
+		if flips[i] {
+			found = append(found, p)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return b.Cost(found[i]) < b.Cost(found[j]) })
+	return found
+}
+
+/** Look for the cheapest perturbation which flips the winner, starting from
+ * the single changes, and the best few pairs of changes and of trials random
+ * ones. ok is false if nothing was found.
+ */
+func (b *Balancer) Find(sens []Sensitivity, trials, climbs int) (best Perturbation, ok bool) {
+	starts := make([]Perturbation, 0)
+	for _, s := range sens {
+		if s.Found {
+			starts = append(starts, Perturbation{s.Change})
+		}
+	}
+
+	pairs := b.PairFlips(sens)
+	for i := 0; i < climbs && i < len(pairs); i++ {
+		starts = append(starts, b.Climb(pairs[i]))
+	}
+
+	random := b.RandomFlips(trials)
+	for i := range sens {
+		for _, p := range random {
+			for _, c := range p {
+				if c.Group == sens[i].Group && c.Attr == sens[i].Attr {
+					sens[i].RandomFlips++
+				}
+			}
+		}
+	}
+	for i := 0; i < climbs && i < len(random); i++ {
+		starts = append(starts, b.Climb(random[i]))
+	}
+
+	for _, p := range starts {
+		if !ok || b.Cost(p) < b.Cost(best) {
+			best, ok = p, true
+		}
+	}
+	return best, ok
+}
+
+func (b *Balancer) Describe(c Change) string {
+	g := b.armies.Groups[c.Group]
+	name := fmt.Sprintf("%s group %d", g.faction, g.id)
+	switch c.Attr {
+	case Units:
+		return fmt.Sprintf("%s units %d -> %d", name, g.units, g.units + c.Delta)
+	case Damage:
+		return fmt.Sprintf("%s damage %d -> %d", name, g.attackDamage, g.attackDamage + c.Delta)
+	}
+	if c.Delta > 0 {
+		return fmt.Sprintf("%s initiative up %d places", name, c.Delta)
+	}
+	return fmt.Sprintf("%s initiative down %d places", name, -c.Delta)
+}
+
+func (b *Balancer) PrintPerturbation(p Perturbation) {
+	for _, c := range p {
+		fmt.Printf("  %-45s %6.2f%%\n", b.Describe(c), 100 * b.ChangeCost(c))
+	}
+	o := b.fight(p)
+	fmt.Printf("  Costing %.2f%%, %s wins with %d units remaining\n", 100 * b.Cost(p), o.Winner, o.Units)
+}
+
+/** Say what was tried when nothing flipped the winner */
+func (b *Balancer) PrintNoFlip(trials int) {
+	outcome := fmt.Sprintf("stops %s winning", b.baseline.Winner)
+	if b.baseline.Winner == Stalemate {
+		outcome = "breaks the stalemate"
+	}
+	fmt.Printf("Nothing up to %.0f%% flips the winner: no change that big to one group's units, damage or initiative, no pair of them and none of %d random sets of them %s. A bigger -maxchange may find one.\n",
+		100 * b.maxChange, trials, outcome)
+}
+
+/** Print the n attributes which take the smallest change on their own to
+ * flip the winner, most sensitive first
+ */
+func (b *Balancer) PrintSensitivities(sens []Sensitivity, n int) {
+	sorted := append([]Sensitivity{}, sens...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Found != sorted[j].Found {
+			return sorted[i].Found
+		}
+		if sorted[i].Cost != sorted[j].Cost {
+			return sorted[i].Cost < sorted[j].Cost
+		}
+		return sorted[i].RandomFlips > sorted[j].RandomFlips
+	})
+	fmt.Printf("%-45s %8s %13s\n", "Smallest single change", "Cost", "Random flips")
+	for i, s := range sorted {
+		if i == n {
+			break
+		}
+		g := b.armies.Groups[s.Group]
+		desc := fmt.Sprintf("%s group %d %s: none", g.faction, g.id, s.Attr)
+		cost := "-"
+		if s.Found {
+			desc = b.Describe(s.Change)
+			cost = fmt.Sprintf("%.2f%%", 100 * s.Cost)
+		}
+		fmt.Printf("%-45s %8s %13d\n", desc, cost, s.RandomFlips)
+	}
+}
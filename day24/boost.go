@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/* Finding the smallest boost
+ *
+ * The bigger the boost the better the boosted faction's side does, so the
+ * smallest winning boost can be found by doubling until that side wins and
+ * then closing in on where it starts winning. Each step tries several boosts
+ * at once, one per worker, each fighting on its own copy of the groups.
+ *
+ * Stalemates spoil that a bit: a boost can leave both sides unable to hurt
+ * each other, and the outcome around those boosts doesn't always go the same
+ * way as the boost goes up. If any turn up below the answer, the search closes
+ * in again on the boosts below the lowest stalemate, where the outcome still
+ * goes one way. If none of those win, it tries each boost between the last
+ * outright loss and the answer, since a stalemate there may have hidden a win.
+ */
+
+const Stalemate = "Stalemate"
+
+type Outcome struct {
+	Boost int
+	Winner string // A side's name, or Stalemate
+	Units int // Left on the winning side
+	Rounds int
+	Won bool // Whether the boosted faction's side won
+}
+
+/** Fight it out on copies of the groups, with faction boosted */
+func (a *Armies) Fight(faction string, boost int) Outcome {
+	groups := CopyGroups(a.Groups)
+	for _, g := range groups {
+		if g.faction == faction {
+			g.attackDamage += boost
+		}
+	}
+	groups, rounds := a.RunBattle(groups, nil)
+	outcome := Outcome{Boost: boost, Winner: Stalemate, Rounds: rounds}
+	if side, over := LastSide(groups); over {
+		outcome.Winner, outcome.Units = a.SideName(side), TotalUnits(groups)
+		outcome.Won = side == a.side[faction]
+	}
+	return outcome
+}
+
+type BoostSearch struct {
+	armies *Armies
+	faction string
+	workers int
+	table map[int]Outcome
+}
+
+func NewBoostSearch(armies *Armies, faction string, workers int) *BoostSearch {
+	if workers < 1 {
+		workers = 1
+	}
+	return &BoostSearch{armies, faction, workers, make(map[int]Outcome)}
+}
+
+/** Fight every boost not already tried, a worker's worth at a time */
+func (s *BoostSearch) evaluate(boosts []int) {
+	todo := make([]int, 0, len(boosts))
+	for _, b := range boosts {
+		if _, ok := s.table[b]; !ok {
+			todo = append(todo, b)
+		}
+	}
+	results := make([]Outcome, len(todo))
+	var wg sync.WaitGroup
+	slots := make(chan bool, s.workers)
+	for i, b := range todo {
+		wg.Add(1)
+		slots <- true
+		go func(i, b int) {
+			defer wg.Done()
+			results[i] = s.armies.Fight(s.faction, b)
+			<-slots
+		}(i, b)
+	}
+	wg.Wait()
+	for _, o := range results {
+		s.table[o.Boost] = o
+	}
+}
+
+/** Find the smallest boost which lets the boosted faction's side win. ok is
+ * false if even maxBoost isn't enough.
+ *
+ * This assumes that below the lowest stalemate the outcome only gets better
+ * for the boosted side as the boost goes up, so that one loss there means
+ * every smaller boost loses too and the search can skip them. Nothing checks
+ * that; an army file where a bigger boost loses a battle a smaller one won,
+ * with no stalemate in between, can give an answer that's too big.
+ */
+func (s *BoostSearch) Find(maxBoost int) (best Outcome, ok bool) {
+	// Double the boost until the boosted side wins, trying a few doublings
+	// at a time
+	lo, hi := 0, -1
+	next := 1
+	for hi < 0 && lo < maxBoost {
+		batch := make([]int, 0, s.workers)
+		for len(batch) < s.workers && (len(batch) == 0 || batch[len(batch)-1] < maxBoost) {
+			if next > maxBoost {
+				next = maxBoost
+			}
+			batch = append(batch, next)
+			next *= 2
+		}
+		s.evaluate(batch)
+		for _, b := range batch {
+			if s.table[b].Won {
+				hi = b
+				break
+			}
+			lo = b
+		}
+	}
+	if hi < 0 {
+		return Outcome{}, false
+	}
+
+	hi = s.narrow(lo, hi)
+
+	lowest := -1
+	for b, o := range s.table {
+		if o.Winner == Stalemate && b < hi && (lowest < 0 || b < lowest) {
+			lowest = b
+		}
+	}
+	if lowest < 0 {
+		return s.table[hi], true
+	}
+
+	// Below the lowest stalemate the boosted side only does better as the
+	// boost goes up, so if the boost just under it wins, the answer's there
+	if lowest > 1 {
+		s.evaluate([]int{lowest - 1})
+		if s.table[lowest - 1].Won {
+			lo := 0
+			for b, o := range s.table {
+				if b < lowest && !o.Won && b > lo {
+					lo = b
+				}
+			}
+			return s.table[s.narrow(lo, lowest - 1)], true
+		}
+	}
+
+	// Otherwise only boosts above the last outright loss can win
+	from := lowest
+	for b, o := range s.table {
+		if b > from && b < hi && o.Winner != Stalemate && !o.Won {
+			from = b
+		}
+	}
+	window := make([]int, 0, hi - from)
+	for b := from + 1; b < hi; b++ {
+		window = append(window, b)
+	}
+	s.evaluate(window)
+	for _, b := range window {
+		if s.table[b].Won {
+			hi = b
+			break
+		}
+	}
+	return s.table[hi], true
+}
+
+/** Narrow down between a boost which doesn't win and one which does,
+ * splitting the gap up into as many pieces as there are workers. Returns the
+ * boost which wins at the end.
+ */
+func (s *BoostSearch) narrow(lo, hi int) int {
+	for hi - lo > 1 {
+		batch := make([]int, 0, s.workers)
+		for i := 1; i <= s.workers; i++ {
+			b := lo + (hi - lo) * i / (s.workers + 1)
+			if b > lo && b < hi && (len(batch) == 0 || batch[len(batch)-1] != b) {
+				batch = append(batch, b)
+			}
+		}
+		s.evaluate(batch)
+		for _, b := range batch {
+			if s.table[b].Won {
+				hi = b
+				break
+			}
+			lo = b
+		}
+	}
+	return hi
+}
+
+/** Every boost that was tried, in order */
+func (s *BoostSearch) Outcomes() []Outcome {
+	outcomes := make([]Outcome, 0, len(s.table))
+	for _, o := range s.table {
+		outcomes = append(outcomes, o)
+	}
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].Boost < outcomes[j].Boost })
+	return outcomes
+}
+
+func (s *BoostSearch) PrintTable() {
+	fmt.Printf("%8s  %-24s %8s %8s\n", "Boost", "Winner", "Units", "Rounds")
+	for _, o := range s.Outcomes() {
+		fmt.Printf("%8d  %-24s %8d %8d\n", o.Boost, o.Winner, o.Units, o.Rounds)
+	}
+}
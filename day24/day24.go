@@ -2,46 +2,68 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"math/big"
 	"os"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 )
 
-type AttackType int
+/* Army files
+ *
+ * An army file lists factions, each a line with the faction's name and a
+ * colon followed by its groups, one per line as in the puzzle. There can be
+ * any number of factions. Each one fights alone unless it's named on an
+ * alliance line like
+ *
+ *   Alliance: Immune System, Antibodies
+ *
+ * and allies never target each other. Inside the brackets a group can be
+ * weak to (2x damage), immune to (no damage) or resistant to (half damage)
+ * attack types, or take any other multiple of the damage with a clause like
+ * "1.5x to fire" or "2/3x to cold". Blank lines and lines starting with #
+ * are skipped. The puzzle's own input is an army file with two factions and
+ * no alliances.
+ */
+
+// Damage taken from an attack type gets multiplied by num/den
+type Multiplier struct {
+	num int
+	den int
+}
+
+var normalDamage = Multiplier{1, 1}
+
+func (m Multiplier) String() string {
+	if m.den == 1 {
+		return fmt.Sprintf("%dx", m.num)
+	}
+	return fmt.Sprintf("%d/%dx", m.num, m.den)
+}
 
 type Group struct {
 	id int
-	isInfection bool
+	faction string
+	side int // Groups on the same side never target each other
 	units int
 	initiative int
 	hitpoints int
 	attackType string
 	attackDamage int
-	immunities []string
-	weaknesses []string
+	modifiers map[string]Multiplier // Only for attack types that don't do normal damage
 
 	selectedTarget *Group
 }
 
-func (g *Group) HasWeakness(dtype string) bool {
-	for _, t := range g.weaknesses {
-		if t == dtype {
-			return true
-		}
+func (g *Group) Multiplier(dtype string) Multiplier {
+	if m, found := g.modifiers[dtype]; found {
+		return m
 	}
-	return false
-}
-
-func (g *Group) HasImmunity(dtype string) bool {
-	for _, t := range g.immunities {
-		if t == dtype {
-			return true
-		}
-	}
-	return false
+	return normalDamage
 }
 
 func (g *Group) EffectivePower() int {
@@ -50,18 +72,30 @@ func (g *Group) EffectivePower() int {
 
 // Compute how much damage this group would do to another
 func (g *Group) Damage(other *Group) int {
-	if other.HasWeakness(g.attackType) {
-		return g.EffectivePower() * 2
-	} else if other.HasImmunity(g.attackType) {
-		return 0
-	} else {
-		return g.EffectivePower()
+	m := other.Multiplier(g.attackType)
+	return g.EffectivePower() * m.num / m.den
+}
+
+/** Parse the multiplier at the start of a clause in a group's brackets */
+func ParseMultiplier(word string) (Multiplier, error) {
+	switch word {
+	case "weak":
+		return Multiplier{2, 1}, nil
+	case "immune":
+		return Multiplier{0, 1}, nil
+	case "resistant":
+		return Multiplier{1, 2}, nil
 	}
+	r, ok := new(big.Rat).SetString(strings.TrimSuffix(word, "x"))
+	if !strings.HasSuffix(word, "x") || !ok || r.Sign() < 0 || !r.Num().IsInt64() || !r.Denom().IsInt64() {
+		return Multiplier{}, fmt.Errorf("Unrecognized specialty %q", word)
+	}
+	return Multiplier{int(r.Num().Int64()), int(r.Denom().Int64())}, nil
 }
 
 func ParseLine(line string) (Group, bool) {
-	re1 := regexp.MustCompile("(\\d+) units each with (\\d+) hit points (\\(.*\\) )?with an attack that does (\\d+) (\\w+) damage at initiative (\\d+)")
-	re2 := regexp.MustCompile("(\\w+) to (.*)")
+	re1 := regexp.MustCompile("^(\\d+) units? each with (\\d+) hit points? (\\(.*\\) )?with an attack that does (\\d+) (\\w+) damage at initiative (\\d+)$")
+	re2 := regexp.MustCompile("^(\\S+) to (.*)$")
 	match := re1.FindStringSubmatch(line)
 	if match == nil {
 		return Group{}, false
@@ -73,72 +107,163 @@ func ParseLine(line string) (Group, bool) {
 	group.attackType = match[5]
 	group.initiative, _ = strconv.Atoi(match[6])
 	if len(match[3]) > 0 {
+		group.modifiers = make(map[string]Multiplier)
+
 		// Remove leading and trailing parens
 		s := match[3][1:len(match[3])-2]
 
 		// Split it on any semicolons
 		commands := strings.Split(s, ";")
 		for _, cmdString := range commands {
-			match = re2.FindStringSubmatch(cmdString)
+			match = re2.FindStringSubmatch(strings.TrimSpace(cmdString))
 			if match == nil {
 				panic("Couldn't match specialty string")
 			}
-			if match[1] == "weak" {
-				group.weaknesses = strings.Split(match[2], ", ")
-			} else if match[1] == "immune" {
-				group.immunities = strings.Split(match[2], ", ")
-			} else {
-				panic("Unrecognized specialty")
+			m, err := ParseMultiplier(match[1])
+			if err != nil {
+				panic(err)
+			}
+			for _, dtype := range strings.Split(match[2], ", ") {
+				group.modifiers[dtype] = m
 			}
 		}
 	}
 	return group, true
 }
 
-func ReadInput(filepath string) ([]*Group, []*Group) {
+type Armies struct {
+	Factions []string // In the order they're listed
+	Groups []*Group // Every group, in the order they're listed
+	side map[string]int
+	sides int
+}
+
+/** The side a faction fights on. ok is false if there's no such faction. */
+func (a *Armies) Side(faction string) (side int, ok bool) {
+	side, ok = a.side[faction]
+	return side, ok
+}
+
+/** The factions on a side joined up, which for a faction with no allies is
+ * just its name
+ */
+func (a *Armies) SideName(side int) string {
+	names := make([]string, 0)
+	for _, f := range a.Factions {
+		if a.side[f] == side {
+			names = append(names, f)
+		}
+	}
+	return strings.Join(names, " & ")
+}
+
+func (a *Armies) Sides() int {
+	return a.sides
+}
+
+func (a *Armies) FactionGroups(faction string) []*Group {
+	groups := make([]*Group, 0)
+	for _, g := range a.Groups {
+		if g.faction == faction {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+/** Put allied factions on the same side, numbering the sides in the order
+ * their first faction is listed
+ */
+func (a *Armies) ally(alliances [][]string) {
+	label := make(map[string]int)
+	for i, f := range a.Factions {
+		label[f] = i
+	}
+	for _, names := range alliances {
+		for _, name := range names {
+			if _, found := label[name]; !found {
+				panic(fmt.Sprintf("Alliance names unknown faction %q", name))
+			}
+		}
+		// Anything allied with any of them joins the first one's side
+		target := label[names[0]]
+		for _, name := range names[1:] {
+			old := label[name]
+			for f, l := range label {
+				if l == old {
+					label[f] = target
+				}
+			}
+		}
+	}
+
+	a.side = make(map[string]int)
+	sides := make(map[int]int)
+	for _, f := range a.Factions {
+		if _, found := sides[label[f]]; !found {
+			sides[label[f]] = len(sides)
+		}
+		a.side[f] = sides[label[f]]
+	}
+	a.sides = len(sides)
+	for _, g := range a.Groups {
+		g.side = a.side[g.faction]
+	}
+}
+
+func ReadInput(filepath string) *Armies {
 	f, err := os.Open(filepath)
 	if err != nil {
 		panic(err)
 	}
+	defer f.Close()
 
-	infection := make([]*Group, 0)
-	immuneSystem := make([]*Group, 0)
+	armies := &Armies{}
+	alliances := make([][]string, 0)
+	ids := make(map[string]int) // Last group id used in each faction
+	faction := ""
 
 	scanner := bufio.NewScanner(f)
-
-	scanner.Scan()
-
-	id := 1
 	for scanner.Scan() {
-		if len(scanner.Text()) == 0 {
-			break
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
 		}
-		group, ok := ParseLine(scanner.Text())
-		if !ok {
-			panic("Couldn't parse line")
+		if strings.HasPrefix(line, "Alliance:") {
+			names := strings.Split(strings.TrimPrefix(line, "Alliance:"), ",")
+			for i := range names {
+				names[i] = strings.TrimSpace(names[i])
+			}
+			alliances = append(alliances, names)
+			continue
 		}
-		group.id = id
-		id++
-		immuneSystem = append(immuneSystem, &group)
-	}
-
-	id = 1
-	scanner.Scan() // skip a line
-	for scanner.Scan() {
-		if len(scanner.Text()) == 0 {
-			break
+		if strings.HasSuffix(line, ":") {
+			faction = strings.TrimSuffix(line, ":")
+			if _, found := ids[faction]; found {
+				panic(fmt.Sprintf("Faction %q listed twice", faction))
+			}
+			ids[faction] = 0
+			armies.Factions = append(armies.Factions, faction)
+			continue
 		}
-		group, ok := ParseLine(scanner.Text())
+		group, ok := ParseLine(line)
 		if !ok {
-			panic("Couldn't parse line")
+			panic(fmt.Sprintf("Couldn't parse line %q", line))
+		}
+		if faction == "" {
+			panic("Group listed before any faction")
 		}
-		group.isInfection = true
-		group.id = id
-		id++
-		infection = append(infection, &group)
+		ids[faction]++
+		group.faction = faction
+		group.id = ids[faction]
+		armies.Groups = append(armies.Groups, &group)
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
 	}
 
-	return immuneSystem, infection
+	armies.ally(alliances)
+	return armies
 }
 
 func LessBySelectOrder(a *Group, b *Group) bool {
@@ -160,10 +285,12 @@ func SortGroups(groups []*Group, SortFn func(*Group, *Group) bool) []*Group {
 }
 
 
-func DoAttackRound(immune, infection []*Group) ([]*Group, []*Group, int) {
-	sortedAll := append(immune, infection...)
-	sortedAll = SortGroups(sortedAll, LessBySelectOrder)
-	targetable := append(immune, infection...)
+/** Fight a single round, recording what happens in log if it isn't nil.
+ * Returns the groups still standing and how many units were killed.
+ */
+func DoAttackRound(groups []*Group, log *RoundLog) ([]*Group, int) {
+	sortedAll := SortGroups(groups, LessBySelectOrder)
+	targetable := groups
 
 	totalUnitsKilled := 0
 	chosen := make(map[*Group]bool)
@@ -171,14 +298,18 @@ func DoAttackRound(immune, infection []*Group) ([]*Group, []*Group, int) {
 	for _, chooser := range sortedAll {
 		targetIdx := -1
 		maxDamage := -1
+		var selection Selection
 		for i, candidate := range targetable {
-			if candidate.isInfection == chooser.isInfection {
+			if candidate.side == chooser.side {
 				continue; // They are on the same team
 			}
 			if _, found := chosen[candidate]; found {
 				continue; // This group is already targetted
 			}
 			appliedDamage := chooser.Damage(candidate)
+			if log != nil && appliedDamage > 0 {
+				selection.Options = append(selection.Options, TargetOption{candidate.faction, candidate.id, appliedDamage})
+			}
 			if appliedDamage == 0 || appliedDamage < maxDamage {
 				continue; // We would do no damage, or less damage than the target we've already chosen
 			}
@@ -199,15 +330,19 @@ func DoAttackRound(immune, infection []*Group) ([]*Group, []*Group, int) {
 		// If a selection was made, save it on the chooser object and remove it
 		// from the targetable list because units can only be targeted once in a round
 		if targetIdx >= 0 {
-			// if chooser.isInfection {
-			// 	fmt.Printf("Infection ")
-			// } else {
-			// 	fmt.Printf("Immune ")
-			// }
-			// fmt.Printf("group %d chooses target group %d\n", chooser.id, targetable[targetIdx].id)
 			chooser.selectedTarget = targetable[targetIdx]
 			chosen[targetable[targetIdx]] = true
 		}
+		if log != nil {
+			selection.Army = chooser.faction
+			selection.Group = chooser.id
+			if chooser.selectedTarget != nil {
+				selection.TargetArmy = chooser.selectedTarget.faction
+				selection.Target = chooser.selectedTarget.id
+				selection.Damage = maxDamage
+			}
+			log.Selections = append(log.Selections, selection)
+		}
 	}
 
 	// Deal damage
@@ -217,42 +352,30 @@ func DoAttackRound(immune, infection []*Group) ([]*Group, []*Group, int) {
 			continue
 		}
 		if attacker.selectedTarget != nil {
-			damage := attacker.Damage(attacker.selectedTarget)
-			unitsKilled := damage / attacker.selectedTarget.hitpoints
-			// if attacker.isInfection {
-			// 	fmt.Printf("Infection group %d deals %d to defending group %d, killing %d units\n", 
-			// 		attacker.id, damage, attacker.selectedTarget.id, unitsKilled)
-			// } else {
-			// 	fmt.Printf("Immune group %d deals %d to defending group %d, killing %d units\n",
-			// 		attacker.id, damage, attacker.selectedTarget.id, unitsKilled)
-			// }
-			if unitsKilled >= attacker.selectedTarget.units {
-				totalUnitsKilled += attacker.selectedTarget.units
-				attacker.selectedTarget.units = 0
-				
-			} else {
-				totalUnitsKilled += unitsKilled
-				attacker.selectedTarget.units -= unitsKilled
+			target := attacker.selectedTarget
+			damage := attacker.Damage(target)
+			unitsKilled := damage / target.hitpoints
+			if unitsKilled > target.units {
+				unitsKilled = target.units
+			}
+			totalUnitsKilled += unitsKilled
+			target.units -= unitsKilled
+			if log != nil {
+				log.Attacks = append(log.Attacks, Attack{attacker.faction, attacker.id, target.faction, target.id, damage, unitsKilled})
 			}
 			// Clear the target
 			attacker.selectedTarget = nil
 		}
 	}
 
-	newImmune := make([]*Group, 0)
-	newInfection := make([]*Group, 0)
 	// Copy any groups that aren't dead
-	for _, g := range immune {
-		if g.units > 0 {
-			newImmune = append(newImmune, g)
-		}
-	}
-	for _, g := range infection {
+	alive := make([]*Group, 0, len(groups))
+	for _, g := range groups {
 		if g.units > 0 {
-			newInfection = append(newInfection, g)
+			alive = append(alive, g)
 		}
 	}
-	return newImmune, newInfection, totalUnitsKilled
+	return alive, totalUnitsKilled
 }
 
 func CopyGroups(toCopy []*Group) []*Group {
@@ -264,81 +387,136 @@ func CopyGroups(toCopy []*Group) []*Group {
 	return ret
 }
 
-func main() {
-	initImmuneGroups, initInfectionGroups := ReadInput("day24_input.txt")
-
-	immuneGroups := CopyGroups(initImmuneGroups)
-	infectionGroups := CopyGroups(initInfectionGroups)
-
-	fmt.Printf("%d immune groups: \n", len(immuneGroups))
-	for _, grp := range immuneGroups {
-		fmt.Println(grp)
+func TotalUnits(groups []*Group) int {
+	units := 0
+	for _, g := range groups {
+		units += g.units
 	}
+	return units
+}
 
-	fmt.Printf("%d infection groups: \n", len(infectionGroups))
-	for _, grp := range infectionGroups {
-		fmt.Println(grp)
+/** The side every group left belongs to. ok is false if more than one side
+ * is still fighting.
+ */
+func LastSide(groups []*Group) (side int, ok bool) {
+	if len(groups) == 0 {
+		return 0, false
 	}
+	for _, g := range groups[1:] {
+		if g.side != groups[0].side {
+			return 0, false
+		}
+	}
+	return groups[0].side, true
+}
 
-	round := 0
+/** Fight until only one side is left, or until a round goes by where nobody
+ * gets killed and it's a stalemate. The groups are changed in place, and the
+ * ones left standing are returned along with the number of rounds fought.
+ * Every round is recorded in log if it isn't nil.
+ */
+func (a *Armies) RunBattle(groups []*Group, log *BattleLog) ([]*Group, int) {
+	if log != nil {
+		log.Factions = a.Factions
+	}
 	unitsKilled := 0
-	for {
-		immuneGroups, infectionGroups, unitsKilled = DoAttackRound(immuneGroups, infectionGroups)
-		round++
-		fmt.Printf("After round %d: \n  Immune: %d groups\n  Infection: %d groups\n\n", round, len(immuneGroups), len(infectionGroups))
-		
-		if len(immuneGroups) == 0 || len(infectionGroups) == 0 || unitsKilled == 0 {
+	round := 1
+	for ; ; round++ {
+		var roundLog *RoundLog
+		if log != nil {
+			roundLog = &RoundLog{Round: round, Groups: groupStates(groups)}
+			log.Rounds = append(log.Rounds, roundLog)
+		}
+		groups, unitsKilled = DoAttackRound(groups, roundLog)
+		if _, over := LastSide(groups); over || unitsKilled == 0 {
 			break
 		}
 	}
+	if log != nil {
+		log.Final = groupStates(groups)
+		if side, over := LastSide(groups); over {
+			log.Winner, log.Units = a.SideName(side), TotalUnits(groups)
+		}
+	}
+	return groups, round
+}
 
-	if len(immuneGroups) == 0 {
-		units := 0
-		for _, g := range infectionGroups {
-			units += g.units
+func main() {
+	inputFile := flag.String("file", "day24_input.txt", "The army file")
+	printLog := flag.Bool("log", false, "Print every round of the part 1 battle the way the puzzle does")
+	jsonFile := flag.String("json", "", "Write the part 1 battle log to this JSON file")
+	boosted := flag.String("boost", "Immune System", "Faction to boost in part 2")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of boosts to try at once")
+	maxBoost := flag.Int("maxboost", 1000000, "Largest boost to try")
+	showTable := flag.Bool("table", false, "Print the outcome of every boost tried")
+	balance := flag.Bool("balance", false, "Look for the smallest change to the groups which flips the part 1 winner")
+	trials := flag.Int("trials", 1000, "Random perturbations to try when balancing")
+	climbs := flag.Int("climbs", 5, "How many of the cheapest random flips to hill climb")
+	maxChange := flag.Float64("maxchange", 2.0, "Largest single change to try when balancing, as a fraction")
+	seed := flag.Int64("seed", 1, "Random seed for balancing")
+	top := flag.Int("top", 10, "How many of the most sensitive attributes to list")
+	flag.Parse()
+
+	armies := ReadInput(*inputFile)
+
+	for _, faction := range armies.Factions {
+		groups := armies.FactionGroups(faction)
+		fmt.Printf("%d %s groups: \n", len(groups), faction)
+		for _, grp := range groups {
+			fmt.Println(grp)
 		}
-		fmt.Printf("Infection wins with %d units remaining\n", units)
-	} else if len(infectionGroups) == 0 {
-		units := 0
-		for _, g := range immuneGroups {
-			units += g.units
+	}
+	for side := 0; side < armies.Sides(); side++ {
+		fmt.Printf("Side %d: %s\n", side + 1, armies.SideName(side))
+	}
+
+	battle := &BattleLog{}
+	groups, _ := armies.RunBattle(CopyGroups(armies.Groups), battle)
+	if *printLog {
+		battle.Print()
+	}
+	if *jsonFile != "" {
+		if err := battle.WriteJSON(*jsonFile); err != nil {
+			panic(err)
 		}
-		fmt.Printf("Immune wins with %d units remaining\n", units)
+	}
+	fmt.Printf("Battle lasted %d rounds\n", len(battle.Rounds))
+
+	if side, over := LastSide(groups); over {
+		fmt.Printf("%s wins with %d units remaining\n", armies.SideName(side), TotalUnits(groups))
 	} else {
 		fmt.Printf("Draw\n")
 	}
 
-	fmt.Println("Part 2...finding boost")
-	boost := 0
-	for {
-		// Reset to initial groups
-		immuneGroups = CopyGroups(initImmuneGroups)
-		infectionGroups = CopyGroups(initInfectionGroups)
-		boost++
-		for _, g := range immuneGroups {
-			g.attackDamage += boost
-		}
-
-		fmt.Println("Trying boost ", boost)
-		// Run to completion
-		unitsKilled := 0
-		for {
-			immuneGroups, infectionGroups, unitsKilled = DoAttackRound(immuneGroups, infectionGroups)
-			
-			if len(immuneGroups) == 0 || len(infectionGroups) == 0  || unitsKilled == 0 {
-				break
-			}
-		}
-		if len(infectionGroups) == 0 {
-			// Immune won!
-			units := 0
-			for _, g := range immuneGroups {
-				units += g.units
-			}
-			fmt.Printf("Immune wins with %d units remaining\n", units)
-			break
+	if *balance {
+		balancer := NewBalancer(armies, *workers, *maxChange, *seed)
+		sens := balancer.Sensitivities()
+		best, ok := balancer.Find(sens, *trials, *climbs)
+		balancer.PrintSensitivities(sens, *top)
+		if ok {
+			fmt.Println("Smallest change found which flips the winner:")
+			balancer.PrintPerturbation(best)
+			fmt.Println("  (found by local search, so a smaller change may exist)")
+		} else {
+			balancer.PrintNoFlip(*trials)
 		}
+		fmt.Printf("Fought %d battles\n", balancer.Fights())
 	}
 
-	fmt.Println("Required boost is ", boost)
-}
\ No newline at end of file
+	if _, ok := armies.Side(*boosted); !ok {
+		panic(fmt.Sprintf("No faction called %q to boost", *boosted))
+	}
+	fmt.Println("Part 2...finding boost")
+	search := NewBoostSearch(armies, *boosted, *workers)
+	best, ok := search.Find(*maxBoost)
+	if *showTable {
+		search.PrintTable()
+	}
+	if !ok {
+		fmt.Printf("%s can't win with a boost of up to %d\n", *boosted, *maxBoost)
+		return
+	}
+	fmt.Printf("Tried %d boosts\n", len(search.Outcomes()))
+	fmt.Printf("%s wins with %d units remaining\n", best.Winner, best.Units)
+	fmt.Println("Required boost is ", best.Boost)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadExample(t *testing.T) {
+	armies := ReadInput("day24_example.txt")
+	want := []Group{
+		{id: 1, faction: "Immune System", side: 0, units: 17, initiative: 2, hitpoints: 5390,
+			attackType: "fire", attackDamage: 4507,
+			modifiers: map[string]Multiplier{"radiation": {2, 1}, "bludgeoning": {2, 1}}},
+		{id: 2, faction: "Immune System", side: 0, units: 989, initiative: 3, hitpoints: 1274,
+			attackType: "slashing", attackDamage: 25,
+			modifiers: map[string]Multiplier{"fire": {0, 1}, "bludgeoning": {2, 1}, "slashing": {2, 1}}},
+		{id: 1, faction: "Infection", side: 1, units: 801, initiative: 1, hitpoints: 4706,
+			attackType: "bludgeoning", attackDamage: 116,
+			modifiers: map[string]Multiplier{"radiation": {2, 1}}},
+		{id: 2, faction: "Infection", side: 1, units: 4485, initiative: 4, hitpoints: 2961,
+			attackType: "slashing", attackDamage: 12,
+			modifiers: map[string]Multiplier{"radiation": {0, 1}, "fire": {2, 1}, "cold": {2, 1}}},
+	}
+	if !reflect.DeepEqual(armies.Factions, []string{"Immune System", "Infection"}) {
+		t.Errorf("Factions %v", armies.Factions)
+	}
+	if len(armies.Groups) != len(want) {
+		t.Fatalf("Read %d groups, want %d", len(armies.Groups), len(want))
+	}
+	for i, g := range armies.Groups {
+		if !reflect.DeepEqual(*g, want[i]) {
+			t.Errorf("Group %d is %+v, want %+v", i, *g, want[i])
+		}
+	}
+}
+
+func TestReadInput(t *testing.T) {
+	armies := ReadInput("day24_input.txt")
+	if armies.Sides() != 2 {
+		t.Fatalf("Read %d sides, want 2", armies.Sides())
+	}
+	for _, faction := range armies.Factions {
+		if n := len(armies.FactionGroups(faction)); n != 10 {
+			t.Errorf("Read %d %s groups, want 10", n, faction)
+		}
+	}
+
+	// One group with both kinds of clause, and one with no brackets
+	want := map[int]Group{
+		1: {id: 2, faction: "Immune System", side: 0, units: 1959, initiative: 20, hitpoints: 7875,
+			attackType: "radiation", attackDamage: 38,
+			modifiers: map[string]Multiplier{"cold": {2, 1}, "slashing": {0, 1}, "bludgeoning": {0, 1}}},
+		2: {id: 3, faction: "Immune System", side: 0, units: 8933, initiative: 15, hitpoints: 5687,
+			attackType: "slashing", attackDamage: 6},
+	}
+	for i, w := range want {
+		if !reflect.DeepEqual(*armies.Groups[i], w) {
+			t.Errorf("Group %d is %+v, want %+v", i, *armies.Groups[i], w)
+		}
+	}
+
+	groups, _ := armies.RunBattle(CopyGroups(armies.Groups), nil)
+	side, over := LastSide(groups)
+	if !over || armies.SideName(side) != "Infection" || TotalUnits(groups) != 14377 {
+		t.Errorf("Part 1 left %d units on side %d (over %v), want Infection with 14377",
+			TotalUnits(groups), side, over)
+	}
+}
+
+/** Run f, returning whatever it prints */
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return <-out
+}
+
+// The first round as the puzzle prints it, word for word
+const exampleFirstRound = `Immune System:
+Group 1 contains 17 units
+Group 2 contains 989 units
+Infection:
+Group 1 contains 801 units
+Group 2 contains 4485 units
+
+Infection group 1 would deal defending group 1 185832 damage
+Infection group 1 would deal defending group 2 185832 damage
+Infection group 2 would deal defending group 2 107640 damage
+Immune System group 1 would deal defending group 1 76619 damage
+Immune System group 1 would deal defending group 2 153238 damage
+Immune System group 2 would deal defending group 1 24725 damage
+
+Infection group 2 attacks defending group 2, killing 84 units
+Immune System group 2 attacks defending group 1, killing 4 units
+Immune System group 1 attacks defending group 2, killing 51 units
+Infection group 1 attacks defending group 1, killing 17 units
+
+`
+
+func TestBattleLogPrint(t *testing.T) {
+	armies := ReadInput("day24_example.txt")
+	battle := &BattleLog{}
+	armies.RunBattle(CopyGroups(armies.Groups), battle)
+	out := captureStdout(t, battle.Print)
+
+	if !strings.HasPrefix(out, exampleFirstRound) {
+		first := out
+		if len(first) > len(exampleFirstRound) {
+			first = first[:len(exampleFirstRound)]
+		}
+		t.Errorf("First round printed as\n%s\nwant\n%s", first, exampleFirstRound)
+	}
+	if want := "Infection wins with 5216 units remaining\n"; !strings.HasSuffix(out, want) {
+		t.Errorf("Battle ends with %q, want %q", out[strings.LastIndex(out[:len(out)-1], "\n")+1:], want)
+	}
+	if len(battle.Rounds) != 8 {
+		t.Errorf("Logged %d rounds, want 8", len(battle.Rounds))
+	}
+}
+
+/** Read an army file written out line by line */
+func loadArmies(t *testing.T, lines ...string) *Armies {
+	path := filepath.Join(t.TempDir(), "armies.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ReadInput(path)
+}
+
+func findBoost(t *testing.T, armies *Armies, workers int) Outcome {
+	best, ok := NewBoostSearch(armies, "Immune System", workers).Find(1000000)
+	if !ok {
+		t.Fatalf("No boost found with %d workers", workers)
+	}
+	return best
+}
+
+func TestBoostExample(t *testing.T) {
+	armies := ReadInput("day24_example.txt")
+	for _, workers := range []int{1, 3, 8} {
+		best := findBoost(t, armies, workers)
+		if best.Boost != 1570 || best.Units != 51 {
+			t.Errorf("With %d workers found boost %d leaving %d units, want 1570 leaving 51",
+				workers, best.Boost, best.Units)
+		}
+	}
+}
+
+func TestBoostStalemates(t *testing.T) {
+	// Just below the answer the battle never ends
+	armies := ReadInput("day24_input.txt")
+	if o := armies.Fight("Immune System", 33); o.Winner != Stalemate {
+		t.Fatalf("Boost 33 gives %s, want a stalemate", o.Winner)
+	}
+	for _, workers := range []int{1, 3, 8} {
+		if best := findBoost(t, armies, workers); best.Boost != 34 {
+			t.Errorf("Input with %d workers found boost %d, want 34", workers, best.Boost)
+		}
+	}
+
+	// Below a boost of 9 the infection's first group kills the immune
+	// system outright, and from 9 up to 98 neither side can kill the other's
+	// last group
+	armies = loadArmies(t,
+		"Immune System:",
+		"10 units each with 10 hit points with an attack that does 1 fire damage at initiative 3",
+		"",
+		"Infection:",
+		"1 unit each with 100 hit points with an attack that does 100 fire damage at initiative 1",
+		"1 unit each with 1000 hit points with an attack that does 5 fire damage at initiative 2")
+	for boost, want := range map[int]string{8: "Infection", 9: Stalemate, 98: Stalemate, 99: "Immune System"} {
+		if o := armies.Fight("Immune System", boost); o.Winner != want {
+			t.Errorf("Boost %d gives %s, want %s", boost, o.Winner, want)
+		}
+	}
+	for _, workers := range []int{1, 3, 8} {
+		if best := findBoost(t, armies, workers); best.Boost != 99 {
+			t.Errorf("Stalemate band with %d workers found boost %d, want 99", workers, best.Boost)
+		}
+	}
+}
+
+func TestBalanceExample(t *testing.T) {
+	armies := ReadInput("day24_example.txt")
+	balancer := NewBalancer(armies, 4, 2.0, 1)
+	if w := balancer.Baseline().Winner; w != "Infection" {
+		t.Fatalf("Baseline winner %s, want Infection", w)
+	}
+	sens := balancer.Sensitivities()
+	best, ok := balancer.Find(sens, 200, 5)
+	if !ok {
+		t.Fatal("Found nothing which flips the example")
+	}
+	if !balancer.Flips(best) {
+		t.Errorf("Best change %v doesn't flip the winner", best)
+	}
+	// Cutting infection group 1's damage to 3 and group 2 to 2486 units
+	// is known to flip it
+	known := Perturbation{{2, Damage, -113}, {3, Units, -1999}}
+	if !balancer.Flips(known) {
+		t.Fatalf("Known change %v doesn't flip the winner", known)
+	}
+	if balancer.Cost(best) > balancer.Cost(known) {
+		t.Errorf("Best change %v costs %.4f, more than the known %.4f", best, balancer.Cost(best), balancer.Cost(known))
+	}
+}
+
+func TestBalanceNoFlip(t *testing.T) {
+	armies := ReadInput("day24_example.txt")
+	balancer := NewBalancer(armies, 4, 0.1, 1)
+	sens := balancer.Sensitivities()
+	for _, s := range sens {
+		if s.Found {
+			t.Errorf("Found a %.2f%% flip %v with changes of up to 10%%", 100*s.Cost, s.Change)
+		}
+	}
+	if best, ok := balancer.Find(sens, 200, 5); ok {
+		t.Errorf("Found a %.2f%% flip %v with changes of up to 10%%", 100*balancer.Cost(best), best)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+/* Battle log
+ *
+ * Everything that happens in a fight gets recorded round by round, so it
+ * can be printed the way the puzzle shows it, or saved as JSON.
+ */
+
+type GroupState struct {
+	Army string `json:"army"`
+	Group int `json:"group"`
+	Units int `json:"units"`
+}
+
+// Damage a group would deal to a group it could pick as its target
+type TargetOption struct {
+	TargetArmy string `json:"target_army"`
+	Target int `json:"target"`
+	Damage int `json:"damage"`
+}
+
+type Selection struct {
+	Army string `json:"army"`
+	Group int `json:"group"`
+	Options []TargetOption `json:"options"` // Every group still free to be picked
+	TargetArmy string `json:"target_army"`
+	Target int `json:"target"` // Zero if none was picked
+	Damage int `json:"damage"`
+}
+
+type Attack struct {
+	Army string `json:"army"`
+	Group int `json:"group"`
+	TargetArmy string `json:"target_army"`
+	Target int `json:"target"`
+	Damage int `json:"damage"`
+	Killed int `json:"killed"`
+}
+
+type RoundLog struct {
+	Round int `json:"round"`
+	Groups []GroupState `json:"groups"` // At the start of the round
+	Selections []Selection `json:"selections"` // In the order groups picked
+	Attacks []Attack `json:"attacks"` // In the order groups attacked
+}
+
+type BattleLog struct {
+	Factions []string `json:"factions"` // In the order they're listed
+	Rounds []*RoundLog `json:"rounds"`
+	Final []GroupState `json:"final"`
+	Winner string `json:"winner"` // The side left standing, empty if it was a stalemate
+	Units int `json:"units"` // Units left on the winning side
+}
+
+func groupStates(groups []*Group) []GroupState {
+	states := make([]GroupState, 0, len(groups))
+	for _, g := range groups {
+		states = append(states, GroupState{g.faction, g.id, g.units})
+	}
+	return states
+}
+
+/** The puzzle only says "defending group 2", which is all it takes to tell
+ * which group is meant when there are just two factions
+ */
+func defending(factions []string, army string, group int) string {
+	if len(factions) == 2 {
+		return fmt.Sprintf("defending group %d", group)
+	}
+	return fmt.Sprintf("defending %s group %d", army, group)
+}
+
+// Groups are listed in the order the factions are, and pick targets the
+// other way round, matching the puzzle's example
+func printGroupStates(factions []string, states []GroupState) {
+	for _, army := range factions {
+		fmt.Printf("%s:\n", army)
+		armyStates := make([]GroupState, 0)
+		for _, s := range states {
+			if s.Army == army {
+				armyStates = append(armyStates, s)
+			}
+		}
+		sort.Slice(armyStates, func(i, j int) bool { return armyStates[i].Group < armyStates[j].Group })
+		if len(armyStates) == 0 {
+			fmt.Println("No groups remain.")
+		}
+		for _, s := range armyStates {
+			fmt.Printf("Group %d contains %d units\n", s.Group, s.Units)
+		}
+	}
+}
+
+/** Print the round the way the puzzle's example does */
+func (r *RoundLog) Print(factions []string) {
+	printGroupStates(factions, r.Groups)
+	fmt.Println()
+	for i := len(factions) - 1; i >= 0; i-- {
+		army := factions[i]
+		armySelections := make([]Selection, 0)
+		for _, s := range r.Selections {
+			if s.Army == army {
+				armySelections = append(armySelections, s)
+			}
+		}
+		sort.Slice(armySelections, func(i, j int) bool { return armySelections[i].Group < armySelections[j].Group })
+		for _, s := range armySelections {
+			for _, o := range s.Options {
+				fmt.Printf("%s group %d would deal %s %d damage\n", s.Army, s.Group, defending(factions, o.TargetArmy, o.Target), o.Damage)
+			}
+		}
+	}
+	fmt.Println()
+	for _, a := range r.Attacks {
+		fmt.Printf("%s group %d attacks %s, killing %d units\n", a.Army, a.Group, defending(factions, a.TargetArmy, a.Target), a.Killed)
+	}
+	fmt.Println()
+}
+
+func (b *BattleLog) Print() {
+	for _, r := range b.Rounds {
+		r.Print(b.Factions)
+	}
+	printGroupStates(b.Factions, b.Final)
+	fmt.Println()
+	if b.Winner == "" {
+		fmt.Println("Stalemate")
+	} else {
+		fmt.Printf("%s wins with %d units remaining\n", b.Winner, b.Units)
+	}
+}
+
+func (b *BattleLog) WriteJSON(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}